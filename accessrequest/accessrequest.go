@@ -0,0 +1,166 @@
+package accessrequest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/skyapps-id/casdoor-test/internal/secrets"
+	"github.com/skyapps-id/casdoor-test/store"
+)
+
+const (
+	keyPrefix      = "access-request:"
+	decisionPrefix = "access-request-decision:"
+)
+
+var (
+	ErrNotFound = errors.New("accessrequest: request not found")
+	ErrDecided  = errors.New("accessrequest: request already decided")
+)
+
+// Request states
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusDenied   = "denied"
+)
+
+// History actions
+const (
+	ActionRequested = "requested"
+	ActionApproved  = "approved"
+	ActionDenied    = "denied"
+	ActionAssigned  = "assigned"
+	ActionFailed    = "assignment_failed"
+)
+
+// Event is one entry of a request's history
+type Event struct {
+	At      time.Time `json:"at"`
+	Actor   string    `json:"actor"`
+	Action  string    `json:"action"`
+	Comment string    `json:"comment,omitempty"`
+}
+
+// Request asks for a role, permanently or for Duration after approval
+type Request struct {
+	ID            string     `json:"id"`
+	Requester     string     `json:"requester"`
+	Role          string     `json:"role"`
+	Justification string     `json:"justification"`
+	Duration      string     `json:"duration,omitempty"`
+	Status        string     `json:"status"`
+	CreatedAt     time.Time  `json:"created_at"`
+	DecidedBy     string     `json:"decided_by,omitempty"`
+	DecidedAt     *time.Time `json:"decided_at,omitempty"`
+	GrantExpires  *time.Time `json:"grant_expires_at,omitempty"`
+	History       []Event    `json:"history"`
+}
+
+// Filter narrows List, empty fields match everything
+type Filter struct {
+	Status    string
+	Requester string
+	Role      string
+}
+
+// Create stores a new pending request
+func Create(ctx context.Context, s store.Store, requester, role, justification, duration string) (*Request, error) {
+	now := time.Now().UTC()
+	req := &Request{
+		ID:            secrets.HexID(8),
+		Requester:     requester,
+		Role:          role,
+		Justification: justification,
+		Duration:      duration,
+		Status:        StatusPending,
+		CreatedAt:     now,
+		History:       []Event{{At: now, Actor: requester, Action: ActionRequested, Comment: justification}},
+	}
+	return req, Save(ctx, s, req)
+}
+
+// Save writes the request, requests are kept as history and never expire
+func Save(ctx context.Context, s store.Store, req *Request) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	return s.Set(ctx, keyPrefix+req.ID, data, 0)
+}
+
+// Get loads one request
+func Get(ctx context.Context, s store.Store, id string) (*Request, error) {
+	data, err := s.Get(ctx, keyPrefix+id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var req Request
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+// List returns the matching requests, newest first
+func List(ctx context.Context, s store.Store, filter Filter) ([]*Request, error) {
+	items, err := s.List(ctx, keyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	requests := []*Request{}
+	for _, data := range items {
+		var req Request
+		if err := json.Unmarshal(data, &req); err != nil {
+			continue
+		}
+		if (filter.Status != "" && req.Status != filter.Status) ||
+			(filter.Requester != "" && req.Requester != filter.Requester) ||
+			(filter.Role != "" && req.Role != filter.Role) {
+			continue
+		}
+		requests = append(requests, &req)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].CreatedAt.After(requests[j].CreatedAt)
+	})
+	return requests, nil
+}
+
+// Claim makes sure only one approver decides a request, also across instances.
+// It fails with ErrDecided when someone else got there first. The claim runs
+// out after ttl so a crashed approver cannot block the request forever.
+func Claim(ctx context.Context, s store.Store, id string, ttl time.Duration) error {
+	ok, err := s.SetNX(ctx, decisionPrefix+id, []byte(time.Now().UTC().Format(time.RFC3339)), ttl)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrDecided
+	}
+	return nil
+}
+
+// Release gives up a claim, e.g. when the approval could not be carried out,
+// so the request can be decided again
+func Release(ctx context.Context, s store.Store, id string) error {
+	return s.Delete(ctx, decisionPrefix+id)
+}
+
+// Record appends an event to the request's history
+func (r *Request) Record(actor, action, comment string) {
+	r.History = append(r.History, Event{
+		At:      time.Now().UTC(),
+		Actor:   actor,
+		Action:  action,
+		Comment: comment,
+	})
+}
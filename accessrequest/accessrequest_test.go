@@ -0,0 +1,114 @@
+package accessrequest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/skyapps-id/casdoor-test/store"
+)
+
+func TestClaimRelease(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemoryStore()
+
+	if err := Claim(ctx, s, "r-1", time.Minute); err != nil {
+		t.Fatalf("first Claim: %v", err)
+	}
+	if err := Claim(ctx, s, "r-1", time.Minute); !errors.Is(err, ErrDecided) {
+		t.Errorf("second Claim error = %v, want ErrDecided", err)
+	}
+	if err := Claim(ctx, s, "r-2", time.Minute); err != nil {
+		t.Errorf("Claim of another request: %v", err)
+	}
+	if err := Release(ctx, s, "r-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Claim(ctx, s, "r-1", time.Minute); err != nil {
+		t.Errorf("Claim after Release: %v", err)
+	}
+}
+
+func TestClaimExpires(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemoryStore()
+
+	if err := Claim(ctx, s, "r-1", time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	// An approver that died mid-decision must not block the request for good
+	if err := Claim(ctx, s, "r-1", time.Minute); err != nil {
+		t.Errorf("Claim after the first claim expired: %v", err)
+	}
+}
+
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemoryStore()
+
+	create := func(requester, role, status string, age time.Duration) *Request {
+		req, err := Create(ctx, s, requester, role, "needed", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Status = status
+		req.CreatedAt = time.Now().Add(-age)
+		if err := Save(ctx, s, req); err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+	a := create("alice", "on-call", StatusPending, 3*time.Hour)
+	b := create("bob", "on-call", StatusApproved, 2*time.Hour)
+	c := create("alice", "admin", StatusDenied, time.Hour)
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []*Request
+	}{
+		{"everything, newest first", Filter{}, []*Request{c, b, a}},
+		{"status", Filter{Status: StatusPending}, []*Request{a}},
+		{"requester", Filter{Requester: "alice"}, []*Request{c, a}},
+		{"role", Filter{Role: "on-call"}, []*Request{b, a}},
+		{"combined", Filter{Requester: "alice", Role: "on-call", Status: StatusApproved}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := List(ctx, s, tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("List returned %d requests, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if got[i].ID != tt.want[i].ID {
+					t.Errorf("List[%d] = %s (%s), want %s", i, got[i].ID, got[i].Requester, tt.want[i].ID)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateGet(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemoryStore()
+
+	req, err := Create(ctx, s, "alice", "on-call", "incident 42", "8h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Get(ctx, s, req.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != StatusPending || got.Duration != "8h" || len(got.History) != 1 || got.History[0].Action != ActionRequested {
+		t.Errorf("Get = %+v, want a pending request with its requested event", got)
+	}
+	if _, err := Get(ctx, s, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(missing) error = %v, want ErrNotFound", err)
+	}
+}
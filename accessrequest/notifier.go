@@ -0,0 +1,67 @@
+package accessrequest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Notifier tells people about access requests: approvers about new ones,
+// requesters about decisions. event is one of the history actions.
+type Notifier interface {
+	Notify(ctx context.Context, event string, req *Request) error
+}
+
+// NopNotifier drops every notification
+type NopNotifier struct{}
+
+func (NopNotifier) Notify(context.Context, string, *Request) error { return nil }
+
+// LogNotifier writes notifications to the application log
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(_ context.Context, event string, req *Request) error {
+	log.Printf("access request %s %s: %s asks for %s", req.ID, event, req.Requester, req.Role)
+	return nil
+}
+
+// WebhookNotifier posts {"event": ..., "request": ...} as JSON to URL,
+// e.g. a chat or ticketing integration
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{URL: url, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, event string, req *Request) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"event":   event,
+		"request": req,
+	})
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.Client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
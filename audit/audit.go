@@ -0,0 +1,98 @@
+package audit
+
+import (
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	DecisionAllow = "allow"
+	DecisionDeny  = "deny"
+)
+
+// Decision is a single authorization decision taken by the RBAC middleware
+type Decision struct {
+	Timestamp time.Time `json:"timestamp"`
+	RequestID string    `json:"request_id"`
+	User      string    `json:"user"`
+	Actor     string    `json:"actor,omitempty"` // real user behind an impersonation
+	Org       string    `json:"org"`
+	Roles     []string  `json:"roles"`
+	Method    string    `json:"method"`
+	Resource  string    `json:"resource"`
+	Decision  string    `json:"decision"`
+	Request   string    `json:"request,omitempty"` // Casbin request tuple that was allowed
+	Reason    string    `json:"reason,omitempty"`
+	ClientIP  string    `json:"client_ip"`
+}
+
+// Sink persists decisions somewhere (file, stdout, database, ...)
+type Sink interface {
+	Write(d *Decision) error
+	Close() error
+}
+
+// Logger fans decisions out to every configured sink.
+// Denies are always written, allows are sampled with AllowSampleRate (0..1).
+type Logger struct {
+	sinks           []Sink
+	allowSampleRate float64
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// NewLogger creates a logger writing to the given sinks
+func NewLogger(allowSampleRate float64, sinks ...Sink) *Logger {
+	return &Logger{
+		sinks:           sinks,
+		allowSampleRate: allowSampleRate,
+		rand:            rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// LogDecision writes the decision to all sinks, errors are logged but never fail the request
+func (l *Logger) LogDecision(d *Decision) {
+	if l == nil || len(l.sinks) == 0 {
+		return
+	}
+	if d.Decision == DecisionAllow && !l.sampled() {
+		return
+	}
+	if d.Timestamp.IsZero() {
+		d.Timestamp = time.Now().UTC()
+	}
+
+	for _, sink := range l.sinks {
+		if err := sink.Write(d); err != nil {
+			log.Printf("audit: failed to write decision: %v", err)
+		}
+	}
+}
+
+// Close closes all sinks
+func (l *Logger) Close() {
+	if l == nil {
+		return
+	}
+	for _, sink := range l.sinks {
+		if err := sink.Close(); err != nil {
+			log.Printf("audit: failed to close sink: %v", err)
+		}
+	}
+}
+
+func (l *Logger) sampled() bool {
+	if l.allowSampleRate >= 1 {
+		return true
+	}
+	if l.allowSampleRate <= 0 {
+		return false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rand.Float64() < l.allowSampleRate
+}
@@ -0,0 +1,156 @@
+package audit
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/skyapps-id/casdoor-test/internal/secrets"
+)
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+
+	redacted = "[REDACTED]"
+)
+
+// Change is an administrative mutation of identity data (users, roles, assignments)
+type Change struct {
+	ID         string        `json:"id"`
+	Timestamp  time.Time     `json:"timestamp"`
+	RequestID  string        `json:"request_id"`
+	Actor      string        `json:"actor"`
+	OnBehalfOf string        `json:"on_behalf_of,omitempty"` // impersonated user, Actor is the real one
+	Action     string        `json:"action"`
+	TargetType string        `json:"target_type"`
+	Target     string        `json:"target"`
+	Diff       []FieldChange `json:"diff"`
+	Outcome    string        `json:"outcome"`
+	Error      string        `json:"error,omitempty"`
+	ClientIP   string        `json:"client_ip"`
+}
+
+// FieldChange is a single top level field that differs between before and after
+type FieldChange struct {
+	Field  string      `json:"field"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// ChangeFilter narrows a query on the change log, zero values match everything
+type ChangeFilter struct {
+	Actor  string
+	Target string
+	Action string
+	From   time.Time
+	To     time.Time
+	Limit  int
+}
+
+// ChangeStore is an append-only, queryable store of changes
+type ChangeStore interface {
+	Append(c *Change) error
+	Query(f ChangeFilter) ([]*Change, error)
+}
+
+// NewChange fills the ID, timestamp and diff of a change record.
+// before/after are any JSON serializable objects, nil meaning "did not exist".
+func NewChange(action, targetType, target string, before, after interface{}) *Change {
+	return &Change{
+		ID:         secrets.HexID(16),
+		Timestamp:  time.Now().UTC(),
+		Action:     action,
+		TargetType: targetType,
+		Target:     target,
+		Diff:       Diff(before, after),
+		Outcome:    OutcomeSuccess,
+	}
+}
+
+// Diff compares the top level JSON fields of two objects, redacting secrets
+func Diff(before, after interface{}) []FieldChange {
+	b := toMap(before)
+	a := toMap(after)
+
+	fields := map[string]struct{}{}
+	for k := range b {
+		fields[k] = struct{}{}
+	}
+	for k := range a {
+		fields[k] = struct{}{}
+	}
+
+	diff := []FieldChange{}
+	for field := range fields {
+		bv, av := b[field], a[field]
+		if reflect.DeepEqual(bv, av) {
+			continue
+		}
+		if isSensitive(field) {
+			if bv != nil && bv != "" {
+				bv = redacted
+			}
+			if av != nil && av != "" {
+				av = redacted
+			}
+		}
+		diff = append(diff, FieldChange{Field: field, Before: bv, After: av})
+	}
+
+	sort.Slice(diff, func(i, j int) bool { return diff[i].Field < diff[j].Field })
+	return diff
+}
+
+// Matches reports whether the change passes the filter
+func (f ChangeFilter) Matches(c *Change) bool {
+	if f.Actor != "" && c.Actor != f.Actor {
+		return false
+	}
+	if f.Target != "" && c.Target != f.Target {
+		return false
+	}
+	if f.Action != "" && c.Action != f.Action {
+		return false
+	}
+	if !f.From.IsZero() && c.Timestamp.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && c.Timestamp.After(f.To) {
+		return false
+	}
+	return true
+}
+
+func toMap(v interface{}) map[string]interface{} {
+	m := map[string]interface{}{}
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil()) {
+		return m
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return m
+	}
+	_ = json.Unmarshal(data, &m)
+	return m
+}
+
+// sensitiveFields are credential fields of Casdoor users that never reach
+// the change log, matched case-insensitively
+var sensitiveFields = map[string]bool{
+	"hash":                 true,
+	"prehash":              true,
+	"accesskey":            true,
+	"accesstoken":          true,
+	"originaltoken":        true,
+	"originalrefreshtoken": true,
+	"recoverycodes":        true,
+}
+
+func isSensitive(field string) bool {
+	f := strings.ToLower(field)
+	return sensitiveFields[f] || strings.Contains(f, "password") || strings.Contains(f, "secret")
+}
@@ -0,0 +1,76 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileChangeStore appends changes to a JSON lines file and scans it on query
+type FileChangeStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileChangeStore creates the parent directory of path when missing
+func NewFileChangeStore(path string) (*FileChangeStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return nil, err
+	}
+	return &FileChangeStore{path: path}, nil
+}
+
+func (s *FileChangeStore) Append(c *Change) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return json.NewEncoder(f).Encode(c)
+}
+
+func (s *FileChangeStore) Query(filter ChangeFilter) ([]*Change, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changes := []*Change{}
+
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return changes, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		var c Change
+		if err := json.Unmarshal(scanner.Bytes(), &c); err != nil {
+			continue
+		}
+		if filter.Matches(&c) {
+			changes = append(changes, &c)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// newest first, like the SQL store
+	for i, j := 0, len(changes)-1; i < j; i, j = i+1, j-1 {
+		changes[i], changes[j] = changes[j], changes[i]
+	}
+	if filter.Limit > 0 && len(changes) > filter.Limit {
+		changes = changes[:filter.Limit]
+	}
+	return changes, nil
+}
@@ -0,0 +1,106 @@
+package audit
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// SQLChangeStore keeps changes in the admin_changes table (SQLite or Postgres)
+type SQLChangeStore struct {
+	db *sql.DB
+}
+
+// NewSQLChangeStore creates the changes table if needed
+func NewSQLChangeStore(db *sql.DB) (*SQLChangeStore, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS admin_changes (
+		id          TEXT PRIMARY KEY,
+		timestamp   TIMESTAMP NOT NULL,
+		request_id  TEXT,
+		actor       TEXT,
+		action      TEXT,
+		target_type TEXT,
+		target      TEXT,
+		diff        TEXT,
+		outcome     TEXT,
+		error       TEXT,
+		client_ip   TEXT
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	addColumn(db, "admin_changes", "on_behalf_of TEXT")
+	return &SQLChangeStore{db: db}, nil
+}
+
+func (s *SQLChangeStore) Append(c *Change) error {
+	diff, err := json.Marshal(c.Diff)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(`INSERT INTO admin_changes
+		(id, timestamp, request_id, actor, action, target_type, target, diff, outcome, error, client_ip, on_behalf_of)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		c.ID, c.Timestamp, c.RequestID, c.Actor, c.Action, c.TargetType, c.Target,
+		string(diff), c.Outcome, c.Error, c.ClientIP, c.OnBehalfOf,
+	)
+	return err
+}
+
+func (s *SQLChangeStore) Query(f ChangeFilter) ([]*Change, error) {
+	where := []string{}
+	args := []interface{}{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+
+	if f.Actor != "" {
+		add("actor = $%d", f.Actor)
+	}
+	if f.Target != "" {
+		add("target = $%d", f.Target)
+	}
+	if f.Action != "" {
+		add("action = $%d", f.Action)
+	}
+	if !f.From.IsZero() {
+		add("timestamp >= $%d", f.From)
+	}
+	if !f.To.IsZero() {
+		add("timestamp <= $%d", f.To)
+	}
+
+	query := `SELECT id, timestamp, request_id, actor, action, target_type, target, diff, outcome, error, client_ip,
+		COALESCE(on_behalf_of, '') FROM admin_changes`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY timestamp DESC"
+	if f.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", f.Limit)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []*Change{}
+	for rows.Next() {
+		var c Change
+		var diff string
+		if err := rows.Scan(&c.ID, &c.Timestamp, &c.RequestID, &c.Actor, &c.Action, &c.TargetType,
+			&c.Target, &diff, &c.Outcome, &c.Error, &c.ClientIP, &c.OnBehalfOf); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(diff), &c.Diff); err != nil {
+			return nil, err
+		}
+		changes = append(changes, &c)
+	}
+	return changes, rows.Err()
+}
@@ -0,0 +1,52 @@
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// WriterSink writes decisions as JSON lines to an io.Writer
+type WriterSink struct {
+	mu  sync.Mutex
+	w   io.Writer
+	enc *json.Encoder
+}
+
+// NewWriterSink creates a JSON lines sink on top of w
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w, enc: json.NewEncoder(w)}
+}
+
+// NewStdoutSink writes decisions to stdout
+func NewStdoutSink() *WriterSink {
+	return NewWriterSink(os.Stdout)
+}
+
+// NewFileSink appends decisions to a JSON lines file, creating it when missing
+func NewFileSink(path string) (*WriterSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriterSink(f), nil
+}
+
+func (s *WriterSink) Write(d *Decision) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(d)
+}
+
+func (s *WriterSink) Close() error {
+	if f, ok := s.w.(*os.File); ok && f != os.Stdout {
+		return f.Close()
+	}
+	return nil
+}
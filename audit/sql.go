@@ -0,0 +1,55 @@
+package audit
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// SQLSink stores decisions in the authz_decisions table (SQLite or Postgres)
+type SQLSink struct {
+	db *sql.DB
+}
+
+// NewSQLSink creates the decisions table if needed
+func NewSQLSink(db *sql.DB) (*SQLSink, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS authz_decisions (
+		timestamp      TIMESTAMP NOT NULL,
+		request_id     TEXT,
+		user_name      TEXT,
+		org            TEXT,
+		roles          TEXT,
+		method         TEXT,
+		resource       TEXT,
+		decision       TEXT NOT NULL,
+		request        TEXT,
+		reason         TEXT,
+		client_ip      TEXT
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	addColumn(db, "authz_decisions", "actor TEXT")
+	addColumn(db, "authz_decisions", "request TEXT")
+	return &SQLSink{db: db}, nil
+}
+
+func (s *SQLSink) Write(d *Decision) error {
+	_, err := s.db.Exec(`INSERT INTO authz_decisions
+		(timestamp, request_id, user_name, org, roles, method, resource, decision, request, reason, client_ip, actor)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		d.Timestamp, d.RequestID, d.User, d.Org, strings.Join(d.Roles, ","),
+		d.Method, d.Resource, d.Decision, d.Request, d.Reason, d.ClientIP, d.Actor,
+	)
+	return err
+}
+
+// addColumn upgrades tables created by older versions. The error is ignored
+// because the column usually exists already (SQLite has no ADD COLUMN IF NOT EXISTS).
+func addColumn(db *sql.DB, table, column string) {
+	_, _ = db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column)
+}
+
+// Close is a no-op, the database handle is owned by the caller
+func (s *SQLSink) Close() error {
+	return nil
+}
@@ -0,0 +1,68 @@
+package claims
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Extra holds the JWT claims Casdoor issues that casdoorsdk.Claims does not expose
+type Extra struct {
+	Nonce    string     `json:"nonce"`
+	Scope    string     `json:"scope"`
+	AuthTime int64      `json:"auth_time"` // unix seconds of the last interactive login
+	AMR      StringList `json:"amr"`       // authentication methods (RFC 8176)
+}
+
+// StringList accepts either a JSON array of strings or a single string
+type StringList []string
+
+func (l *StringList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*l = strings.Fields(single)
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// Scopes splits the space separated scope claim
+func (e *Extra) Scopes() []string {
+	return strings.Fields(e.Scope)
+}
+
+// AuthenticatedAt returns the auth_time claim, zero when absent
+func (e *Extra) AuthenticatedAt() time.Time {
+	if e.AuthTime == 0 {
+		return time.Time{}
+	}
+	return time.Unix(e.AuthTime, 0)
+}
+
+// ParseExtra decodes the payload of a JWT WITHOUT verifying it.
+// Only call it on tokens already verified by casdoorsdk ParseJwtToken.
+func ParseExtra(token string) (*Extra, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("claims: malformed token")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	var extra Extra
+	if err := json.Unmarshal(payload, &extra); err != nil {
+		return nil, err
+	}
+	return &extra, nil
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	"github.com/skyapps-id/casdoor-test/accessrequest"
+)
+
+// AccessRequestSettings configures the access request workflow
+type AccessRequestSettings struct {
+	// Approvers maps a requestable role to the roles that may approve it
+	// (ACCESS_APPROVERS_<ROLE>), DefaultApprovers covers every other role
+	Approvers        map[string][]string
+	DefaultApprovers []string
+}
+
+var AccessRequests AccessRequestSettings
+
+// AccessNotifier delivers access request notifications
+var AccessNotifier accessrequest.Notifier
+
+// InitAccessRequests loads approver rules and the notifier from the environment
+func InitAccessRequests() {
+	AccessRequests = AccessRequestSettings{
+		Approvers:        map[string][]string{},
+		DefaultApprovers: GetEnvList("ACCESS_APPROVERS_DEFAULT", []string{"admin"}),
+	}
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		role, ok := strings.CutPrefix(name, "ACCESS_APPROVERS_")
+		if ok && role != "DEFAULT" {
+			AccessRequests.Approvers[strings.ToLower(role)] = GetEnvList(name, nil)
+		}
+	}
+
+	switch notifier := GetEnv("ACCESS_REQUEST_NOTIFIER", "log"); notifier {
+	case "none":
+		AccessNotifier = accessrequest.NopNotifier{}
+	case "log":
+		AccessNotifier = accessrequest.LogNotifier{}
+	case "webhook":
+		url := GetEnv("ACCESS_REQUEST_WEBHOOK_URL", "")
+		if url == "" {
+			log.Fatal("ACCESS_REQUEST_WEBHOOK_URL is required for the webhook notifier")
+		}
+		AccessNotifier = accessrequest.NewWebhookNotifier(url)
+	default:
+		log.Fatalf("Unknown ACCESS_REQUEST_NOTIFIER: %s (use log, webhook or none)", notifier)
+	}
+}
+
+// ApproverRoles returns the roles allowed to approve requests for role.
+// Env names cannot hold "-" and are not case sensitive, so ACCESS_APPROVERS_ON_CALL
+// covers "on-call", "on_call" and "On-Call" alike: roles that differ only in
+// those characters always share their approvers.
+func ApproverRoles(role string) []string {
+	key := strings.ToLower(strings.ReplaceAll(role, "-", "_"))
+	if approvers, ok := AccessRequests.Approvers[key]; ok {
+		return approvers
+	}
+	return AccessRequests.DefaultApprovers
+}
@@ -0,0 +1,30 @@
+package config
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestApproverRoles(t *testing.T) {
+	AccessRequests = AccessRequestSettings{
+		Approvers:        map[string][]string{"on_call": {"security"}},
+		DefaultApprovers: []string{"admin"},
+	}
+
+	tests := []struct {
+		role string
+		want []string
+	}{
+		{"on_call", []string{"security"}},
+		// "-" and case cannot be told apart in env names
+		{"on-call", []string{"security"}},
+		{"On-Call", []string{"security"}},
+		{"oncall", []string{"admin"}},
+		{"billing", []string{"admin"}},
+	}
+	for _, tt := range tests {
+		if got := ApproverRoles(tt.role); !slices.Equal(got, tt.want) {
+			t.Errorf("ApproverRoles(%q) = %v, want %v", tt.role, got, tt.want)
+		}
+	}
+}
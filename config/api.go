@@ -0,0 +1,39 @@
+package config
+
+import "time"
+
+// APISettings holds behaviour switches of the management API
+type APISettings struct {
+	// PUT/PATCH/DELETE on users and roles fail with 428 without If-Match
+	RequireIfMatch bool
+	// Page sizes of list endpoints
+	DefaultPageSize int
+	MaxPageSize     int
+	// Bulk user import: files above ImportAsyncRows run as background jobs,
+	// kept for ImportJobTTL, with at most ImportConcurrency Casdoor calls at once
+	ImportMaxRows     int
+	ImportAsyncRows   int
+	ImportConcurrency int
+	ImportJobTTL      time.Duration
+	// Temporary role grants: longest allowed expiry and how often expired
+	// grants are removed from Casdoor
+	RoleGrantMaxTTL     time.Duration
+	RoleGrantReapPeriod time.Duration
+}
+
+var API APISettings
+
+// InitAPI loads the management API settings from the environment
+func InitAPI() {
+	API = APISettings{
+		RequireIfMatch:      GetEnvBool("REQUIRE_IF_MATCH", false),
+		DefaultPageSize:     GetEnvInt("DEFAULT_PAGE_SIZE", 20),
+		MaxPageSize:         GetEnvInt("MAX_PAGE_SIZE", 100),
+		ImportMaxRows:       GetEnvInt("IMPORT_MAX_ROWS", 5000),
+		ImportAsyncRows:     GetEnvInt("IMPORT_ASYNC_ROWS", 100),
+		ImportConcurrency:   GetEnvInt("IMPORT_CONCURRENCY", 4),
+		ImportJobTTL:        GetEnvDuration("IMPORT_JOB_TTL", 24*time.Hour),
+		RoleGrantMaxTTL:     GetEnvDuration("ROLE_GRANT_MAX_TTL", 30*24*time.Hour),
+		RoleGrantReapPeriod: GetEnvDuration("ROLE_GRANT_REAP_INTERVAL", time.Minute),
+	}
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"database/sql"
+	"log"
+
+	"github.com/skyapps-id/casdoor-test/audit"
+)
+
+var (
+	AuditLogger *audit.Logger
+	ChangeLog   audit.ChangeStore
+)
+
+// InitAudit builds the authorization audit logger and the administrative
+// change log from AUDIT_* env variables
+func InitAudit() {
+	sinks := []audit.Sink{}
+
+	for _, name := range GetEnvList("AUDIT_SINKS", []string{"stdout"}) {
+		switch name {
+		case "stdout":
+			sinks = append(sinks, audit.NewStdoutSink())
+		case "file":
+			path := GetEnv("AUDIT_FILE", "./logs/audit.jsonl")
+			sink, err := audit.NewFileSink(path)
+			if err != nil {
+				log.Fatalf("Failed to open audit file %s: %v", path, err)
+			}
+			sinks = append(sinks, sink)
+		case "sql":
+			sink, err := audit.NewSQLSink(openAuditDatabase())
+			if err != nil {
+				log.Fatalf("Failed to prepare audit table: %v", err)
+			}
+			sinks = append(sinks, sink)
+		default:
+			log.Fatalf("Unknown audit sink: %s (use stdout, file or sql)", name)
+		}
+	}
+
+	AuditLogger = audit.NewLogger(GetEnvFloat("AUDIT_ALLOW_SAMPLE_RATE", 1), sinks...)
+	log.Printf("📝 Audit logger initialized with sinks %v", GetEnvList("AUDIT_SINKS", []string{"stdout"}))
+
+	switch store := GetEnv("AUDIT_CHANGE_STORE", "file"); store {
+	case "file":
+		path := GetEnv("AUDIT_CHANGE_FILE", "./logs/changes.jsonl")
+		changeLog, err := audit.NewFileChangeStore(path)
+		if err != nil {
+			log.Fatalf("Failed to open change log %s: %v", path, err)
+		}
+		ChangeLog = changeLog
+	case "sql":
+		changeLog, err := audit.NewSQLChangeStore(openAuditDatabase())
+		if err != nil {
+			log.Fatalf("Failed to prepare change log table: %v", err)
+		}
+		ChangeLog = changeLog
+	default:
+		log.Fatalf("Unknown change log store: %s (use file or sql)", store)
+	}
+	log.Printf("📝 Change log initialized with %s store", GetEnv("AUDIT_CHANGE_STORE", "file"))
+}
+
+func openAuditDatabase() *sql.DB {
+	db, err := OpenDatabase(GetEnv("AUDIT_DB_DRIVER", "sqlite"), GetEnv("AUDIT_DB_DSN", "./audit.db"))
+	if err != nil {
+		log.Fatalf("Failed to open audit database: %v", err)
+	}
+	return db
+}
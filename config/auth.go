@@ -0,0 +1,153 @@
+package config
+
+import (
+	"crypto/rand"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// AuthSettings holds the OAuth login flow configuration
+type AuthSettings struct {
+	RedirectURL       string
+	CookieSecret      []byte
+	CookieSecure      bool
+	LoginTTL          time.Duration
+	ReturnToAllowlist []string
+	TokenDelivery     string // "body", "cookie" or "session"
+	RefreshTokenTTL   time.Duration
+	SessionTTL        time.Duration
+	TrustedOrigins    []string
+	PATDefaultTTL     time.Duration
+	PATMaxTTL         time.Duration
+	// Tokens issued to these clients (first-party apps) are not scope restricted
+	ScopeExemptClients    []string
+	DeviceVerificationURI string
+	DeviceCodeTTL         time.Duration
+	DevicePollInterval    time.Duration
+	ImpersonationTTL      time.Duration
+	// Step-up: sensitive routes need a login newer than StepUpMaxAge, with MFA
+	// when StepUpRequireMFA (any of the StepUpMFAMethods in the amr claim)
+	StepUpMaxAge     time.Duration
+	StepUpRequireMFA bool
+	StepUpMFAMethods []string
+}
+
+var Auth AuthSettings
+
+// InitAuth loads the login flow settings from the environment
+func InitAuth() {
+	Auth = AuthSettings{
+		RedirectURL:           GetEnv("CASDOOR_REDIRECT_URL", "http://localhost:9000/callback"),
+		CookieSecret:          []byte(GetEnv("SESSION_SECRET", "")),
+		CookieSecure:          GetEnvBool("COOKIE_SECURE", false),
+		LoginTTL:              GetEnvDuration("LOGIN_STATE_TTL", 10*time.Minute),
+		ReturnToAllowlist:     GetEnvList("LOGIN_RETURN_TO_ALLOWLIST", []string{}),
+		TokenDelivery:         GetEnv("TOKEN_DELIVERY", "body"),
+		RefreshTokenTTL:       GetEnvDuration("REFRESH_TOKEN_TTL", 7*24*time.Hour),
+		SessionTTL:            GetEnvDuration("SESSION_TTL", 12*time.Hour),
+		TrustedOrigins:        GetEnvList("CSRF_TRUSTED_ORIGINS", originsOf(GetEnvList("LOGIN_RETURN_TO_ALLOWLIST", []string{}))),
+		PATDefaultTTL:         GetEnvDuration("PAT_DEFAULT_TTL", 30*24*time.Hour),
+		PATMaxTTL:             GetEnvDuration("PAT_MAX_TTL", 90*24*time.Hour),
+		ScopeExemptClients:    GetEnvList("SCOPE_EXEMPT_CLIENTS", []string{CasdoorClient.ClientId}),
+		DeviceVerificationURI: GetEnv("DEVICE_VERIFICATION_URI", "http://localhost:9000/device"),
+		DeviceCodeTTL:         GetEnvDuration("DEVICE_CODE_TTL", 10*time.Minute),
+		DevicePollInterval:    GetEnvDuration("DEVICE_POLL_INTERVAL", 5*time.Second),
+		ImpersonationTTL:      GetEnvDuration("IMPERSONATION_TTL", 15*time.Minute),
+		StepUpMaxAge:          GetEnvDuration("STEP_UP_MAX_AGE", 5*time.Minute),
+		StepUpRequireMFA:      GetEnvBool("STEP_UP_REQUIRE_MFA", true),
+		StepUpMFAMethods:      GetEnvList("STEP_UP_MFA_METHODS", []string{"mfa", "otp", "sms", "hwk", "swk"}),
+	}
+
+	switch Auth.TokenDelivery {
+	case "body", "cookie", "session":
+	default:
+		log.Fatalf("Unknown TOKEN_DELIVERY: %s (use body, cookie or session)", Auth.TokenDelivery)
+	}
+
+	if len(Auth.CookieSecret) == 0 {
+		// Random secret only works for a single instance and invalidates cookies on restart
+		Auth.CookieSecret = make([]byte, 32)
+		if _, err := rand.Read(Auth.CookieSecret); err != nil {
+			log.Fatalf("Failed to generate cookie secret: %v", err)
+		}
+		log.Println("⚠️  SESSION_SECRET not set, using a random cookie secret")
+	}
+}
+
+// IsAllowedReturnTo reports whether a post-login redirect target is safe.
+// Relative paths are always allowed, absolute URLs must match an allowlist
+// entry on scheme, host and path (on a segment boundary). Whitespace and
+// control characters are rejected, browsers strip them and "/\t/evil.com"
+// would become "//evil.com".
+func IsAllowedReturnTo(returnTo string) bool {
+	if strings.IndexFunc(returnTo, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0 {
+		return false
+	}
+
+	target, err := url.Parse(returnTo)
+	if err != nil || target.User != nil {
+		return false
+	}
+
+	if strings.HasPrefix(returnTo, "/") {
+		return !strings.HasPrefix(returnTo, "//") && !strings.HasPrefix(returnTo, "/\\") &&
+			target.Scheme == "" && target.Host == ""
+	}
+	if target.Scheme == "" || target.Host == "" {
+		return false
+	}
+
+	for _, entry := range Auth.ReturnToAllowlist {
+		allowed, err := url.Parse(entry)
+		if err != nil {
+			continue
+		}
+		if strings.EqualFold(target.Scheme, allowed.Scheme) &&
+			strings.EqualFold(target.Host, allowed.Host) &&
+			underPath(target.Path, allowed.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// underPath reports whether path is base or below it, so "/app" covers
+// "/app/settings" but not "/application"
+func underPath(path, base string) bool {
+	base = strings.TrimSuffix(base, "/")
+	return base == "" || path == base || strings.HasPrefix(path, base+"/")
+}
+
+// IsTrustedOrigin reports whether a cookie-authenticated request may come from origin
+// (scheme://host as sent in the Origin header)
+func IsTrustedOrigin(origin string) bool {
+	for _, trusted := range Auth.TrustedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(trusted, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func originsOf(urls []string) []string {
+	origins := []string{}
+	for _, raw := range urls {
+		if u, err := url.Parse(raw); err == nil && u.Scheme != "" && u.Host != "" {
+			origins = append(origins, u.Scheme+"://"+u.Host)
+		}
+	}
+	return origins
+}
+
+// IsScopeExempt reports whether tokens issued to clientID skip scope checks
+func IsScopeExempt(clientID string) bool {
+	for _, exempt := range Auth.ScopeExemptClients {
+		if exempt == clientID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package config
+
+import "testing"
+
+func TestIsAllowedReturnTo(t *testing.T) {
+	Auth.ReturnToAllowlist = []string{"https://app.example.com/app", "https://admin.example.com"}
+
+	tests := []struct {
+		returnTo string
+		want     bool
+	}{
+		{"/", true},
+		{"/users?page=2", true},
+		{"/app/settings#top", true},
+		{"//evil.com", false},
+		{"/\\evil.com", false},
+		{"/\t/evil.com", false},
+		{"/\r\n/evil.com", false},
+		{"/ /evil.com", false},
+		{"/\x00", false},
+		{"https://app.example.com/app", true},
+		{"https://app.example.com/app/", true},
+		{"https://app.example.com/app/settings", true},
+		{"https://APP.example.com/app", true},
+		{"https://app.example.com/application", false},
+		{"https://app.example.com/", false},
+		{"http://app.example.com/app", false},
+		{"https://admin.example.com/anything", true},
+		{"https://user@app.example.com/app", false},
+		{"https://evil.com/app", false},
+		{"javascript:alert(1)", false},
+		{"evil.com", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := IsAllowedReturnTo(tt.returnTo); got != tt.want {
+			t.Errorf("IsAllowedReturnTo(%q) = %v, want %v", tt.returnTo, got, tt.want)
+		}
+	}
+}
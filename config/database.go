@@ -0,0 +1,63 @@
+package config
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	databasesMu sync.Mutex
+	databases   = map[string]*sql.DB{}
+)
+
+// OpenDatabase returns a shared connection pool for the driver/DSN pair.
+// Supported drivers: "sqlite" and "postgres".
+func OpenDatabase(driver, dsn string) (*sql.DB, error) {
+	switch driver {
+	case "sqlite", "sqlite3":
+		driver = "sqlite3"
+	case "postgres", "postgresql":
+		driver = "postgres"
+	default:
+		return nil, fmt.Errorf("unsupported database driver: %s", driver)
+	}
+
+	databasesMu.Lock()
+	defer databasesMu.Unlock()
+
+	key := driver + "|" + dsn
+	if db, ok := databases[key]; ok {
+		return db, nil
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if driver == "sqlite3" {
+		// SQLite only allows a single writer
+		db.SetMaxOpenConns(1)
+	}
+
+	databases[key] = db
+	return db, nil
+}
+
+// CloseDatabases closes every pool opened through OpenDatabase
+func CloseDatabases() {
+	databasesMu.Lock()
+	defer databasesMu.Unlock()
+
+	for key, db := range databases {
+		db.Close()
+		delete(databases, key)
+	}
+}
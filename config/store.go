@@ -0,0 +1,40 @@
+package config
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/skyapps-id/casdoor-test/store"
+)
+
+var Store store.Store
+
+// InitStore sets up the server-side state store from STORE_* env variables.
+// Use "sql" when running more than one instance so state is shared.
+func InitStore() {
+	switch driver := GetEnv("STORE_DRIVER", "memory"); driver {
+	case "memory":
+		Store = store.NewMemoryStore()
+	case "sql":
+		db, err := OpenDatabase(GetEnv("STORE_DB_DRIVER", "sqlite"), GetEnv("STORE_DB_DSN", "./store.db"))
+		if err != nil {
+			log.Fatalf("Failed to open store database: %v", err)
+		}
+		sqlStore, err := store.NewSQLStore(db)
+		if err != nil {
+			log.Fatalf("Failed to prepare store table: %v", err)
+		}
+		go func() {
+			for range time.Tick(10 * time.Minute) {
+				if err := sqlStore.Cleanup(context.Background()); err != nil {
+					log.Printf("store: cleanup failed: %v", err)
+				}
+			}
+		}()
+		Store = sqlStore
+	default:
+		log.Fatalf("Unknown store driver: %s (use memory or sql)", driver)
+	}
+	log.Printf("🗄️  State store initialized with %s driver", GetEnv("STORE_DRIVER", "memory"))
+}
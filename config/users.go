@@ -0,0 +1,28 @@
+package config
+
+// UserFieldPolicy maps a role to the user fields it may change through
+// PUT/PATCH /api/users/:username, "*" allows every field
+var UserFieldPolicy map[string][]string
+
+// InitUserFields loads the per-role field allowlists (USER_FIELDS_<ROLE>)
+func InitUserFields() {
+	UserFieldPolicy = map[string][]string{
+		"admin": GetEnvList("USER_FIELDS_ADMIN", []string{"*"}),
+		"manager": GetEnvList("USER_FIELDS_MANAGER", []string{
+			"display_name", "first_name", "last_name", "phone", "country_code",
+			"avatar", "title", "affiliation", "region", "location", "language",
+		}),
+	}
+}
+
+// UserFieldAllowed reports whether one of the roles may change field
+func UserFieldAllowed(roles []string, field string) bool {
+	for _, role := range roles {
+		for _, allowed := range UserFieldPolicy[role] {
+			if allowed == "*" || allowed == field {
+				return true
+			}
+		}
+	}
+	return false
+}
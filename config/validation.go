@@ -0,0 +1,33 @@
+package config
+
+import (
+	"log"
+
+	"github.com/skyapps-id/casdoor-test/validation"
+)
+
+// Validator enforces the `validate` struct tags of request bodies
+var Validator *validation.Validator
+
+// InitValidation builds the request validator from USERNAME_PATTERN and the
+// PASSWORD_* policy, loading the breached password list if configured
+func InitValidation() {
+	policy := validation.PasswordPolicy{
+		MinLength:  GetEnvInt("PASSWORD_MIN_LENGTH", 8),
+		MinClasses: GetEnvInt("PASSWORD_MIN_CLASSES", 3),
+	}
+	if path := GetEnv("PASSWORD_BREACHED_LIST", ""); path != "" {
+		list, err := validation.LoadBreachedList(path)
+		if err != nil {
+			log.Fatalf("Failed to load breached password list: %v", err)
+		}
+		policy.Breached = list
+		log.Printf("Loaded %d breached passwords", len(list))
+	}
+
+	v, err := validation.New(GetEnv("USERNAME_PATTERN", `^[a-zA-Z0-9][a-zA-Z0-9_.-]{2,38}$`), policy)
+	if err != nil {
+		log.Fatalf("Failed to set up validation: %v", err)
+	}
+	Validator = v
+}
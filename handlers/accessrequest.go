@@ -0,0 +1,349 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/casdoor/casdoor-go-sdk/casdoorsdk"
+	"github.com/labstack/echo/v4"
+	"github.com/skyapps-id/casdoor-test/accessrequest"
+	"github.com/skyapps-id/casdoor-test/config"
+	"github.com/skyapps-id/casdoor-test/problem"
+	"github.com/skyapps-id/casdoor-test/rolegrant"
+	"github.com/skyapps-id/casdoor-test/validation"
+)
+
+// accessClaimTTL bounds how long one approver holds a request while deciding
+const accessClaimTTL = time.Minute
+
+// CreateAccessRequest asks for a role, permanently or for duration once approved
+func CreateAccessRequest(c echo.Context) error {
+	user, ok := c.Get("casdoorUser").(*casdoorsdk.User)
+	if !ok || user == nil {
+		return problem.Unauthorized("Unauthorized")
+	}
+
+	var req struct {
+		Role          string `json:"role" validate:"required,identifier"`
+		Justification string `json:"justification" validate:"required,max=500"`
+		Duration      string `json:"duration"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return problem.BadRequest("Invalid request")
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(err)
+	}
+	if req.Duration != "" {
+		duration, err := time.ParseDuration(req.Duration)
+		if err != nil || duration <= 0 {
+			return validationFailed(validation.Errors{{Field: "duration", Rule: "duration", Message: "must be a positive duration like 8h"}})
+		}
+		if duration > config.API.RoleGrantMaxTTL {
+			return validationFailed(validation.Errors{{Field: "duration", Rule: "max", Message: "must be at most " + config.API.RoleGrantMaxTTL.String()}})
+		}
+	}
+
+	role, err := config.CasdoorClient.GetRole(req.Role)
+	if err != nil {
+		return problem.Casdoor(err, "Failed to get role")
+	}
+	if role == nil {
+		return problem.NotFound("Role not found")
+	}
+	if !role.IsEnabled {
+		return problem.Conflict("Role is disabled")
+	}
+
+	ctx := c.Request().Context()
+	// casdoorUser no longer lists expired grants, a temporary role may be extended
+	if hasRole(user, req.Role) {
+		if _, err := rolegrant.Get(ctx, config.Store, user.Name, req.Role); errors.Is(err, rolegrant.ErrNotFound) {
+			return problem.Conflict("Role is already assigned")
+		} else if err != nil {
+			return problem.Internal("Failed to load role grant", err)
+		}
+	}
+	pending, err := accessrequest.List(ctx, config.Store, accessrequest.Filter{
+		Status:    accessrequest.StatusPending,
+		Requester: user.Name,
+		Role:      req.Role,
+	})
+	if err != nil {
+		return problem.Internal("Failed to list access requests", err)
+	}
+	if len(pending) > 0 {
+		return problem.Conflict("An access request for this role is already pending").With("id", pending[0].ID)
+	}
+
+	request, err := accessrequest.Create(ctx, config.Store, user.Name, req.Role, req.Justification, req.Duration)
+	recordChange(c, "access_request.create", "access_request", request.ID, nil, request, err)
+	if err != nil {
+		return problem.Internal("Failed to store access request", err)
+	}
+	notifyAccessRequest(accessrequest.ActionRequested, request)
+
+	return c.JSON(http.StatusCreated, request)
+}
+
+// ListAccessRequests returns the requests the caller made or may decide, newest first.
+// Filters: status (pending|approved|denied), role, requester.
+func ListAccessRequests(c echo.Context) error {
+	user, ok := c.Get("casdoorUser").(*casdoorsdk.User)
+	if !ok || user == nil {
+		return problem.Unauthorized("Unauthorized")
+	}
+	pg, err := parsePage(c)
+	if err != nil {
+		return problem.BadRequest(err.Error())
+	}
+
+	filter := accessrequest.Filter{
+		Status:    c.QueryParam("status"),
+		Requester: c.QueryParam("requester"),
+		Role:      c.QueryParam("role"),
+	}
+	switch filter.Status {
+	case "", accessrequest.StatusPending, accessrequest.StatusApproved, accessrequest.StatusDenied:
+	default:
+		return problem.BadRequest("status must be pending, approved or denied")
+	}
+
+	requests, err := accessrequest.List(c.Request().Context(), config.Store, filter)
+	if err != nil {
+		return problem.Internal("Failed to list access requests", err)
+	}
+	visible := []*accessrequest.Request{}
+	for _, request := range requests {
+		if request.Requester == user.Name || canApprove(user, request.Role) {
+			visible = append(visible, request)
+		}
+	}
+
+	total := len(visible)
+	start := min(pg.offset(), total)
+	end := min(start+pg.Size, total)
+
+	setPaginationHeaders(c, pg, total)
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"requests":  visible[start:end],
+		"total":     total,
+		"page":      pg.Number,
+		"page_size": pg.Size,
+	})
+}
+
+// GetAccessRequest returns one request with its history
+func GetAccessRequest(c echo.Context) error {
+	user, ok := c.Get("casdoorUser").(*casdoorsdk.User)
+	if !ok || user == nil {
+		return problem.Unauthorized("Unauthorized")
+	}
+
+	request, err := accessrequest.Get(c.Request().Context(), config.Store, c.Param("id"))
+	if errors.Is(err, accessrequest.ErrNotFound) {
+		return problem.NotFound("Access request not found")
+	}
+	if err != nil {
+		return problem.Internal("Failed to load access request", err)
+	}
+	// Requests of others stay hidden unless the caller may decide them
+	if request.Requester != user.Name && !canApprove(user, request.Role) {
+		return problem.NotFound("Access request not found")
+	}
+
+	return c.JSON(http.StatusOK, request)
+}
+
+// ApproveAccessRequest grants the requested role to the requester
+func ApproveAccessRequest(c echo.Context) error {
+	return decideAccessRequest(c, true)
+}
+
+// DenyAccessRequest rejects a request, a comment is required
+func DenyAccessRequest(c echo.Context) error {
+	return decideAccessRequest(c, false)
+}
+
+// decideAccessRequest approves or denies a pending request. Only holders of
+// the role's approver roles may decide, never the requester. On approval the
+// role is assigned right away; when that fails the request stays pending.
+func decideAccessRequest(c echo.Context, approve bool) error {
+	user, ok := c.Get("casdoorUser").(*casdoorsdk.User)
+	if !ok || user == nil {
+		return problem.Unauthorized("Unauthorized")
+	}
+
+	var req struct {
+		Comment string `json:"comment" validate:"max=500"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return problem.BadRequest("Invalid request")
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(err)
+	}
+	if !approve && req.Comment == "" {
+		return validationFailed(validation.Errors{{Field: "comment", Rule: "required", Message: "is required"}})
+	}
+
+	ctx := c.Request().Context()
+	id := c.Param("id")
+	request, err := accessrequest.Get(ctx, config.Store, id)
+	if errors.Is(err, accessrequest.ErrNotFound) {
+		return problem.NotFound("Access request not found")
+	}
+	if err != nil {
+		return problem.Internal("Failed to load access request", err)
+	}
+	if request.Requester == user.Name {
+		return problem.Forbidden("Access requests cannot be decided by their requester")
+	}
+	if !canApprove(user, request.Role) {
+		return problem.NotFound("Access request not found")
+	}
+	if request.Status != accessrequest.StatusPending {
+		return problem.Conflict("Access request is already " + request.Status)
+	}
+
+	// Only one approver wins, also when two decide at the same moment
+	if err := accessrequest.Claim(ctx, config.Store, id, accessClaimTTL); errors.Is(err, accessrequest.ErrDecided) {
+		return problem.Conflict("Access request is already being decided")
+	} else if err != nil {
+		return problem.Internal("Failed to claim access request", err)
+	}
+	// Claims expire, a decision stored by an earlier claim must still win
+	request, err = accessrequest.Get(ctx, config.Store, id)
+	if err != nil {
+		releaseAccessRequest(ctx, id)
+		return problem.Internal("Failed to load access request", err)
+	}
+	if request.Status != accessrequest.StatusPending {
+		releaseAccessRequest(ctx, id)
+		return problem.Conflict("Access request is already " + request.Status)
+	}
+
+	cc := changeContextOf(c)
+	before := *request
+	before.History = append([]accessrequest.Event(nil), request.History...)
+
+	action, event := "access_request.deny", accessrequest.ActionDenied
+	if approve {
+		action, event = "access_request.approve", accessrequest.ActionApproved
+
+		var expiresAt *time.Time
+		if request.Duration != "" {
+			duration, err := time.ParseDuration(request.Duration)
+			if err != nil {
+				releaseAccessRequest(ctx, id)
+				return problem.Internal("Invalid duration on access request", err)
+			}
+			expires := time.Now().UTC().Add(duration)
+			expiresAt = &expires
+		}
+
+		_, grant, _, err := assignRole(ctx, cc, request.Requester, request.Role, "", expiresAt)
+		if err != nil {
+			// Left pending so it can be approved again once the cause is fixed
+			request.Record(cc.actor, accessrequest.ActionFailed, assignmentFailure(request, err))
+			if saveErr := accessrequest.Save(ctx, config.Store, request); saveErr != nil {
+				log.Printf("access requests: failed to save %s: %v", id, saveErr)
+			}
+			releaseAccessRequest(ctx, id)
+			cc.record(action, "access_request", id, &before, request, err)
+			return accessAssignmentError(c, err)
+		}
+		if grant != nil {
+			request.GrantExpires = &grant.ExpiresAt
+		}
+	}
+
+	now := time.Now().UTC()
+	request.Status = event
+	request.DecidedBy = cc.actor
+	request.DecidedAt = &now
+	request.Record(cc.actor, event, req.Comment)
+	if approve {
+		request.Record(cc.actor, accessrequest.ActionAssigned, "")
+	}
+
+	err = accessrequest.Save(ctx, config.Store, request)
+	cc.record(action, "access_request", id, &before, request, err)
+	if err != nil {
+		// The role is assigned already, the claim holds off other approvers
+		// until it runs out; approving again only repeats the assignment
+		return problem.Internal("Failed to store access request", err)
+	}
+	notifyAccessRequest(event, request)
+
+	return c.JSON(http.StatusOK, request)
+}
+
+// accessAssignmentError answers a failed assignment on approval
+func accessAssignmentError(c echo.Context, err error) error {
+	if handled, resp := roleUpdateError(c, err); handled {
+		return resp
+	}
+	switch {
+	case errors.Is(err, errPermanentRole):
+		return problem.Conflict("Role is already assigned without expiry")
+	case errors.Is(err, errGrantStore):
+		return problem.Internal("Failed to store role grant", err)
+	}
+	return problem.Casdoor(err, "Failed to assign role")
+}
+
+// assignmentFailure is the history comment of a failed assignment, readable
+// by the requester, so Casdoor's message stays in the log
+func assignmentFailure(request *accessrequest.Request, err error) string {
+	switch {
+	case errors.Is(err, errPermanentRole):
+		return "role is already assigned without expiry"
+	case errors.Is(err, errGrantStore):
+		log.Printf("access requests: storing the grant of %s failed: %v", request.ID, err)
+		return "role grant could not be stored"
+	}
+	return memberError(request.Requester, request.Role, err)
+}
+
+func releaseAccessRequest(ctx context.Context, id string) {
+	if err := accessrequest.Release(ctx, config.Store, id); err != nil {
+		log.Printf("access requests: failed to release claim on %s: %v", id, err)
+	}
+}
+
+// notifyAccessRequest sends the notification in the background, a slow or
+// failing notifier never fails the request
+func notifyAccessRequest(event string, request *accessrequest.Request) {
+	if config.AccessNotifier == nil {
+		return
+	}
+	snapshot := *request
+	go func() {
+		if err := config.AccessNotifier.Notify(context.Background(), event, &snapshot); err != nil {
+			log.Printf("access requests: failed to notify %s of %s: %v", event, snapshot.ID, err)
+		}
+	}()
+}
+
+// canApprove reports whether user holds one of the approver roles of role
+func canApprove(user *casdoorsdk.User, role string) bool {
+	for _, approver := range config.ApproverRoles(role) {
+		if hasRole(user, approver) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasRole(user *casdoorsdk.User, roleName string) bool {
+	for _, role := range user.Roles {
+		if role.Name == roleName {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/casdoor/casdoor-go-sdk/casdoorsdk"
+	"github.com/labstack/echo/v4"
+	"github.com/skyapps-id/casdoor-test/accessrequest"
+	"github.com/skyapps-id/casdoor-test/config"
+	"github.com/skyapps-id/casdoor-test/problem"
+	"github.com/skyapps-id/casdoor-test/store"
+	"github.com/skyapps-id/casdoor-test/validation"
+)
+
+func TestAssignmentFailure(t *testing.T) {
+	request := &accessrequest.Request{ID: "r-1", Requester: "alice", Role: "on-call"}
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{errPermanentRole, "role is already assigned without expiry"},
+		{fmt.Errorf("%w: disk full", errGrantStore), "role grant could not be stored"},
+		{errRoleDisabled, "role is disabled"},
+		{errors.New("Error 1406: Data too long for column 'roles'"), "update failed"},
+	}
+	for _, tt := range tests {
+		if got := assignmentFailure(request, tt.err); got != tt.want {
+			t.Errorf("assignmentFailure(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+// setupAccessRequests points the workflow at a memory store: on-call is
+// approved by security, everything else by admin
+func setupAccessRequests(t *testing.T) {
+	t.Helper()
+	config.Store = store.NewMemoryStore()
+	config.AccessRequests = config.AccessRequestSettings{
+		Approvers:        map[string][]string{"on_call": {"security"}},
+		DefaultApprovers: []string{"admin"},
+	}
+	config.AccessNotifier = nil
+	config.ChangeLog = nil
+	config.API.DefaultPageSize, config.API.MaxPageSize = 20, 100
+}
+
+func accessUser(name string, roles ...string) *casdoorsdk.User {
+	user := &casdoorsdk.User{Owner: "skyapps", Name: name}
+	for _, role := range roles {
+		user.Roles = append(user.Roles, &casdoorsdk.Role{Owner: "skyapps", Name: role})
+	}
+	return user
+}
+
+func accessContext(t *testing.T, method, target, body string, user *casdoorsdk.User, id string) (echo.Context, *httptest.ResponseRecorder) {
+	t.Helper()
+	v, err := validation.New(`^[a-z0-9_-]+$`, validation.PasswordPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := echo.New()
+	e.Validator = v
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.Set("casdoorUser", user)
+	if id != "" {
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+	}
+	return c, rec
+}
+
+func statusOf(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+	return problem.From(err).Status
+}
+
+func TestDecideAccessRequest(t *testing.T) {
+	setupAccessRequests(t)
+	ctx := context.Background()
+
+	request, err := accessrequest.Create(ctx, config.Store, "alice", "on-call", "incident 42", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	denied, _ := accessrequest.Create(ctx, config.Store, "bob", "on-call", "incident 43", "")
+	denied.Status = accessrequest.StatusDenied
+	_ = accessrequest.Save(ctx, config.Store, denied)
+	claimed, _ := accessrequest.Create(ctx, config.Store, "carol", "on-call", "incident 44", "")
+	_ = accessrequest.Claim(ctx, config.Store, claimed.ID, time.Minute)
+
+	tests := []struct {
+		name    string
+		user    *casdoorsdk.User
+		id      string
+		approve bool
+		body    string
+		want    int
+	}{
+		{"requester approves own request", accessUser("alice", "security"), request.ID, true, `{}`, http.StatusForbidden},
+		{"requester denies own request", accessUser("alice", "security"), request.ID, false, `{"comment":"no"}`, http.StatusForbidden},
+		{"not an approver of the role", accessUser("dave", "admin"), request.ID, true, `{}`, http.StatusNotFound},
+		{"unknown request", accessUser("erin", "security"), "missing", true, `{}`, http.StatusNotFound},
+		{"already decided", accessUser("erin", "security"), denied.ID, true, `{}`, http.StatusConflict},
+		{"being decided by someone else", accessUser("erin", "security"), claimed.ID, false, `{"comment":"no"}`, http.StatusConflict},
+		{"deny needs a comment", accessUser("erin", "security"), request.ID, false, `{}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := accessContext(t, http.MethodPost, "/api/access-requests/"+tt.id, tt.body, tt.user, tt.id)
+			if got := statusOf(decideAccessRequest(c, tt.approve)); got != tt.want {
+				t.Errorf("decideAccessRequest = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	// None of the refusals may have touched the request
+	if got, _ := accessrequest.Get(ctx, config.Store, request.ID); got.Status != accessrequest.StatusPending {
+		t.Fatalf("request is %s after refused decisions, want pending", got.Status)
+	}
+}
+
+func TestDenyAccessRequest(t *testing.T) {
+	setupAccessRequests(t)
+	ctx := context.Background()
+
+	request, err := accessrequest.Create(ctx, config.Store, "alice", "on-call", "incident 42", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, rec := accessContext(t, http.MethodPost, "/", `{"comment":"not on the rota"}`, accessUser("erin", "security"), request.ID)
+	if err := decideAccessRequest(c, false); err != nil {
+		t.Fatalf("deny: %v", err)
+	}
+	var got accessrequest.Request
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	last := got.History[len(got.History)-1]
+	if got.Status != accessrequest.StatusDenied || got.DecidedBy != "erin" || last.Action != accessrequest.ActionDenied || last.Comment != "not on the rota" {
+		t.Errorf("denied request = %+v", got)
+	}
+
+	// The claim outlives the decision, the status keeps a second one out
+	_ = accessrequest.Release(ctx, config.Store, request.ID)
+	c, _ = accessContext(t, http.MethodPost, "/", `{}`, accessUser("frank", "security"), request.ID)
+	if got := statusOf(decideAccessRequest(c, true)); got != http.StatusConflict {
+		t.Errorf("approving a denied request = %d, want 409", got)
+	}
+}
+
+func TestAccessRequestVisibility(t *testing.T) {
+	setupAccessRequests(t)
+	ctx := context.Background()
+
+	onCall, _ := accessrequest.Create(ctx, config.Store, "alice", "on-call", "incident 42", "")
+	admin, _ := accessrequest.Create(ctx, config.Store, "bob", "admin", "migration", "")
+
+	tests := []struct {
+		name string
+		user *casdoorsdk.User
+		want []string
+	}{
+		{"requester sees own", accessUser("alice"), []string{onCall.ID}},
+		{"approver sees the roles they decide", accessUser("erin", "security"), []string{onCall.ID}},
+		{"default approver", accessUser("dave", "admin"), []string{admin.ID}},
+		{"bystander sees nothing", accessUser("mallory", "user"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, rec := accessContext(t, http.MethodGet, "/api/access-requests", "", tt.user, "")
+			if err := ListAccessRequests(c); err != nil {
+				t.Fatal(err)
+			}
+			var body struct {
+				Requests []accessrequest.Request `json:"requests"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			ids := []string(nil)
+			for _, r := range body.Requests {
+				ids = append(ids, r.ID)
+			}
+			if fmt.Sprint(ids) != fmt.Sprint(tt.want) {
+				t.Errorf("visible = %v, want %v", ids, tt.want)
+			}
+
+			for _, request := range []*accessrequest.Request{onCall, admin} {
+				c, _ := accessContext(t, http.MethodGet, "/", "", tt.user, request.ID)
+				visible := false
+				for _, id := range tt.want {
+					visible = visible || id == request.ID
+				}
+				want := http.StatusNotFound
+				if visible {
+					want = http.StatusOK
+				}
+				if got := statusOf(GetAccessRequest(c)); got != want {
+					t.Errorf("GetAccessRequest(%s) = %d, want %d", request.Requester, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/casdoor/casdoor-go-sdk/casdoorsdk"
+	"github.com/labstack/echo/v4"
+	"github.com/skyapps-id/casdoor-test/audit"
+	"github.com/skyapps-id/casdoor-test/config"
+	"github.com/skyapps-id/casdoor-test/problem"
+)
+
+var errNotAffected = errors.New("no rows affected")
+
+// recordChange appends an administrative change to the change log.
+// before/after are the target state around the mutation, nil when it does not exist.
+func recordChange(c echo.Context, action, targetType, target string, before, after interface{}, err error) {
+	changeContextOf(c).record(action, targetType, target, before, after, err)
+}
+
+// changeContext is who made a change and from where, captured from the
+// request so work that outlives it (import jobs) can still be attributed
+type changeContext struct {
+	requestID  string
+	clientIP   string
+	actor      string
+	onBehalfOf string
+}
+
+func changeContextOf(c echo.Context) changeContext {
+	cc := changeContext{
+		requestID: c.Response().Header().Get(echo.HeaderXRequestID),
+		clientIP:  c.RealIP(),
+	}
+	if actor, ok := c.Get("casdoorUser").(*casdoorsdk.User); ok && actor != nil {
+		cc.actor = actor.Name
+	}
+	if impersonator, ok := c.Get("impersonator").(string); ok {
+		cc.onBehalfOf = cc.actor
+		cc.actor = impersonator
+	}
+	return cc
+}
+
+func (cc changeContext) record(action, targetType, target string, before, after interface{}, err error) {
+	if config.ChangeLog == nil {
+		return
+	}
+
+	change := audit.NewChange(action, targetType, target, before, after)
+	change.RequestID = cc.requestID
+	change.ClientIP = cc.clientIP
+	change.Actor = cc.actor
+	change.OnBehalfOf = cc.onBehalfOf
+	if err != nil {
+		change.Outcome = audit.OutcomeFailure
+		change.Error = err.Error()
+	}
+
+	if err := config.ChangeLog.Append(change); err != nil {
+		log.Printf("audit: failed to append change: %v", err)
+	}
+}
+
+// mutationError turns the (affected, err) pair returned by Casdoor into a single error
+func mutationError(affected bool, err error) error {
+	if err != nil {
+		return err
+	}
+	if !affected {
+		return errNotAffected
+	}
+	return nil
+}
+
+// upstreamError turns a failed Casdoor mutation into a problem; notAffected is
+// returned when Casdoor accepted the call but changed nothing
+func upstreamError(affected bool, err error, detail string, notAffected *problem.Error) error {
+	if err != nil {
+		return problem.Casdoor(err, detail)
+	}
+	if !affected && notAffected != nil {
+		return notAffected
+	}
+	return problem.Internal(detail, errNotAffected)
+}
+
+// ListAuditLog returns administrative changes filtered by actor, target, action and time range.
+// Use ?format=csv or ?format=jsonl to export.
+func ListAuditLog(c echo.Context) error {
+	filter := audit.ChangeFilter{
+		Actor:  c.QueryParam("actor"),
+		Target: c.QueryParam("target"),
+		Action: c.QueryParam("action"),
+	}
+
+	var err error
+	if from := c.QueryParam("from"); from != "" {
+		if filter.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return problem.BadRequest("Invalid from, expected RFC3339 timestamp")
+		}
+	}
+	if to := c.QueryParam("to"); to != "" {
+		if filter.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return problem.BadRequest("Invalid to, expected RFC3339 timestamp")
+		}
+	}
+	if limit := c.QueryParam("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 0 {
+			return problem.BadRequest("Invalid limit")
+		}
+	}
+
+	changes, err := config.ChangeLog.Query(filter)
+	if err != nil {
+		return problem.Internal("Failed to query audit log", err)
+	}
+
+	switch c.QueryParam("format") {
+	case "", "json":
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"changes": changes,
+			"total":   len(changes),
+		})
+	case "jsonl":
+		return exportChangesJSONL(c, changes)
+	case "csv":
+		return exportChangesCSV(c, changes)
+	default:
+		return problem.BadRequest("Invalid format, use json, jsonl or csv")
+	}
+}
+
+func exportChangesJSONL(c echo.Context, changes []*audit.Change) error {
+	c.Response().Header().Set(echo.HeaderContentType, "application/x-ndjson")
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="audit.jsonl"`)
+	c.Response().WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(c.Response())
+	for _, change := range changes {
+		if err := enc.Encode(change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func exportChangesCSV(c echo.Context, changes []*audit.Change) error {
+	c.Response().Header().Set(echo.HeaderContentType, "text/csv")
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="audit.csv"`)
+	c.Response().WriteHeader(http.StatusOK)
+
+	w := csv.NewWriter(c.Response())
+	_ = w.Write([]string{"id", "timestamp", "request_id", "actor", "action", "target_type", "target", "diff", "outcome", "error", "client_ip", "on_behalf_of"})
+	for _, change := range changes {
+		diff, _ := json.Marshal(change.Diff)
+		record := []string{
+			change.ID,
+			change.Timestamp.Format(time.RFC3339),
+			change.RequestID,
+			change.Actor,
+			change.Action,
+			change.TargetType,
+			change.Target,
+			string(diff),
+			change.Outcome,
+			change.Error,
+			change.ClientIP,
+			change.OnBehalfOf,
+		}
+		// Actors, targets and diffs come from users, like the user export
+		for i := range record {
+			record[i] = escapeFormula(record[i])
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/skyapps-id/casdoor-test/claims"
+	"github.com/skyapps-id/casdoor-test/config"
+	"github.com/skyapps-id/casdoor-test/internal/secrets"
+	"github.com/skyapps-id/casdoor-test/problem"
+	"github.com/skyapps-id/casdoor-test/securecookie"
+	"golang.org/x/oauth2"
+)
+
+const loginCookieName = "oauth_login"
+
+// loginState is kept in a signed short-lived cookie between /login and /callback
+type loginState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	ReturnTo string `json:"return_to,omitempty"`
+	// DeviceCode links the login to a pending device authorization (RFC 8628)
+	DeviceCode string `json:"device_code,omitempty"`
+}
+
+func oauthConfig() *oauth2.Config {
+	endpoint := config.CasdoorClient.Endpoint
+	return &oauth2.Config{
+		ClientID:     config.CasdoorClient.ClientId,
+		ClientSecret: config.CasdoorClient.ClientSecret,
+		RedirectURL:  config.Auth.RedirectURL,
+		Scopes:       []string{"openid", "profile", "email"},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:   endpoint + "/login/oauth/authorize",
+			TokenURL:  endpoint + "/api/login/oauth/access_token",
+			AuthStyle: oauth2.AuthStyleInParams,
+		},
+	}
+}
+
+// GetLoginURL starts the authorization code flow with state, nonce and PKCE (S256).
+// ?return_to= sets the post-login redirect (must be relative or allowlisted),
+// ?redirect=true answers with a 302 instead of the JSON url.
+// ?prompt=login and ?max_age= are forwarded to Casdoor for step-up re-authentication.
+func GetLoginURL(c echo.Context) error {
+	returnTo := c.QueryParam("return_to")
+	if returnTo != "" && !config.IsAllowedReturnTo(returnTo) {
+		return problem.BadRequest("return_to is not allowed")
+	}
+
+	opts := []oauth2.AuthCodeOption{}
+	if prompt := c.QueryParam("prompt"); prompt != "" {
+		if prompt != "login" {
+			return problem.BadRequest("prompt must be login")
+		}
+		opts = append(opts, oauth2.SetAuthURLParam("prompt", prompt))
+	}
+	if maxAge := c.QueryParam("max_age"); maxAge != "" {
+		if n, err := strconv.Atoi(maxAge); err != nil || n < 0 {
+			return problem.BadRequest("Invalid max_age")
+		}
+		opts = append(opts, oauth2.SetAuthURLParam("max_age", maxAge))
+	}
+
+	url, err := startLogin(c, loginState{ReturnTo: returnTo}, opts...)
+	if err != nil {
+		return problem.Internal("Failed to start login", err)
+	}
+
+	if c.QueryParam("redirect") == "true" {
+		return c.Redirect(http.StatusFound, url)
+	}
+	return c.JSON(http.StatusOK, map[string]string{
+		"url": url,
+	})
+}
+
+// startLogin generates state, nonce and PKCE verifier, stores them in the
+// signed login cookie and returns the Casdoor authorize URL
+func startLogin(c echo.Context, state loginState, opts ...oauth2.AuthCodeOption) (string, error) {
+	state.State = secrets.Token()
+	state.Nonce = secrets.Token()
+	state.Verifier = oauth2.GenerateVerifier()
+
+	value, err := securecookie.Encode(config.Auth.CookieSecret, loginCookieName, state, config.Auth.LoginTTL)
+	if err != nil {
+		return "", err
+	}
+	c.SetCookie(&http.Cookie{
+		Name:     loginCookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(config.Auth.LoginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   config.Auth.CookieSecure,
+		SameSite: http.SameSiteLaxMode, // must survive the top-level redirect back from Casdoor
+	})
+
+	opts = append(opts,
+		oauth2.S256ChallengeOption(state.Verifier),
+		oauth2.SetAuthURLParam("nonce", state.Nonce),
+	)
+	return oauthConfig().AuthCodeURL(state.State, opts...), nil
+}
+
+func HandleCallback(c echo.Context) error {
+	state, err := consumeLoginState(c)
+	if err != nil {
+		return problem.BadRequest(err.Error())
+	}
+
+	code := c.QueryParam("code")
+	if code == "" {
+		// The user cancelled at Casdoor, a waiting device must learn about it
+		if state.DeviceCode != "" {
+			return denyDevice(c, state.DeviceCode)
+		}
+		return problem.BadRequest("Missing code")
+	}
+
+	token, err := exchangeCode(c.Request().Context(), code, state.Verifier)
+	if err != nil {
+		log.Printf("OAuth code exchange failed: %v", err)
+		return problem.BadRequest("Failed to get token")
+	}
+
+	if err := verifyNonce(token, state.Nonce); err != nil {
+		return problem.BadRequest(err.Error())
+	}
+
+	if err := trackRefreshToken(c.Request().Context(), token, ""); err != nil {
+		return problem.Internal("Failed to store refresh token", err)
+	}
+
+	if state.DeviceCode != "" {
+		return approveDevice(c, state.DeviceCode, token)
+	}
+	return respondWithTokens(c, token, state.ReturnTo)
+}
+
+// consumeLoginState reads and clears the login cookie, then checks the state param against it
+func consumeLoginState(c echo.Context) (*loginState, error) {
+	cookie, err := c.Cookie(loginCookieName)
+	if err != nil {
+		return nil, errors.New("missing login state, restart the login")
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     loginCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   config.Auth.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	var state loginState
+	if err := securecookie.Decode(config.Auth.CookieSecret, loginCookieName, cookie.Value, &state); err != nil {
+		return nil, errors.New("invalid or expired login state, restart the login")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(state.State), []byte(c.QueryParam("state"))) != 1 {
+		return nil, errors.New("state mismatch")
+	}
+	return &state, nil
+}
+
+// exchangeCode trades the authorization code for tokens, proving possession of the PKCE verifier
+func exchangeCode(ctx context.Context, code, verifier string) (*oauth2.Token, error) {
+	token, err := oauthConfig().Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, err
+	}
+
+	// Casdoor reports some errors inside the access token field
+	if strings.HasPrefix(token.AccessToken, "error:") {
+		return nil, errors.New(strings.TrimPrefix(token.AccessToken, "error: "))
+	}
+	return token, nil
+}
+
+// verifyNonce checks the nonce echoed in the signed ID token (or access token when absent)
+func verifyNonce(token *oauth2.Token, nonce string) error {
+	raw, _ := token.Extra("id_token").(string)
+	if raw == "" {
+		raw = token.AccessToken
+	}
+
+	if _, err := config.CasdoorClient.ParseJwtToken(raw); err != nil {
+		return errors.New("invalid ID token")
+	}
+	extra, err := claims.ParseExtra(raw)
+	if err != nil {
+		return errors.New("invalid ID token")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(extra.Nonce), []byte(nonce)) != 1 {
+		return errors.New("nonce mismatch")
+	}
+	return nil
+}
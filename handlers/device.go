@@ -0,0 +1,362 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/skyapps-id/casdoor-test/config"
+	"github.com/skyapps-id/casdoor-test/internal/secrets"
+	"github.com/skyapps-id/casdoor-test/store"
+	"golang.org/x/oauth2"
+)
+
+const (
+	deviceGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+	deviceKeyPrefix       = "device:"
+	deviceUserKeyPrefix   = "device-user:"
+	devicePollKeyPrefix   = "device-poll:"
+	devicePickedKeyPrefix = "device-picked:"
+
+	deviceConfirmCookie = "device_confirm"
+
+	deviceStatusPending  = "pending"
+	deviceStatusApproved = "approved"
+	deviceStatusDenied   = "denied"
+
+	// RFC 8628 section 6.1: no vowels, so codes cannot spell words
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+)
+
+// deviceAuthorization is the server-side state of one device flow, keyed by the
+// hash of the device code. Only the browser side writes it; polling state is
+// kept apart in devicePoll so a poll can never overwrite an approval.
+type deviceAuthorization struct {
+	UserCode  string        `json:"user_code"`
+	ClientID  string        `json:"client_id,omitempty"`
+	RequestIP string        `json:"request_ip"`
+	Status    string        `json:"status"`
+	CreatedAt time.Time     `json:"created_at"`
+	ExpiresAt time.Time     `json:"expires_at"`
+	Token     *oauth2.Token `json:"token,omitempty"`
+	IDToken   string        `json:"id_token,omitempty"`
+}
+
+// devicePoll tracks the polling rate of the device (RFC 8628 section 3.5)
+type devicePoll struct {
+	Interval time.Duration `json:"interval"`
+	LastPoll time.Time     `json:"last_poll"`
+}
+
+var deviceTemplate = template.Must(template.New("device").Parse(`<!DOCTYPE html>
+<html><head><title>Device login</title></head>
+<body>
+{{if .Message}}<p>{{.Message}}</p>{{end}}
+{{if .Form}}<form method="get" action="/device">
+<label>Enter the code shown on your device: <input name="user_code" autofocus></label>
+<button type="submit">Continue</button>
+</form>{{end}}
+{{with .Confirm}}<h1>Sign in on a device?</h1>
+<p>A device is asking to sign in with your account.</p>
+<dl>
+<dt>Code</dt><dd><strong>{{.UserCode}}</strong></dd>
+<dt>Client</dt><dd>{{if .ClientID}}{{.ClientID}}{{else}}not specified{{end}}</dd>
+<dt>Requested from</dt><dd>{{.RequestIP}} at {{.CreatedAt.Format "2006-01-02 15:04 MST"}}</dd>
+</dl>
+<p>Only approve if you started this sign-in yourself and the code matches the one on your device.
+If someone sent you this link, deny it.</p>
+<form method="post" action="/device">
+<input type="hidden" name="user_code" value="{{.UserCode}}">
+<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+<button type="submit" name="action" value="approve">Approve</button>
+<button type="submit" name="action" value="deny">Deny</button>
+</form>{{end}}
+</body></html>`))
+
+// deviceConfirmation is what the confirmation page shows before the user
+// decides on a device request
+type deviceConfirmation struct {
+	UserCode  string
+	ClientID  string
+	RequestIP string
+	CreatedAt time.Time
+	CSRFToken string
+}
+
+// StartDeviceAuthorization (POST /auth/device) issues a device code and user code
+func StartDeviceAuthorization(c echo.Context) error {
+	deviceCode := secrets.Token()
+	userCode := newUserCode()
+	ctx := c.Request().Context()
+
+	now := time.Now()
+	auth := &deviceAuthorization{
+		UserCode:  userCode,
+		ClientID:  c.FormValue("client_id"),
+		RequestIP: c.RealIP(),
+		Status:    deviceStatusPending,
+		CreatedAt: now.UTC(),
+		ExpiresAt: now.Add(config.Auth.DeviceCodeTTL),
+	}
+	if err := saveDevice(ctx, secrets.Hash(deviceCode), auth); err != nil {
+		return deviceError(c, http.StatusInternalServerError, "server_error")
+	}
+	if err := config.Store.Set(ctx, deviceUserKeyPrefix+userCode, []byte(secrets.Hash(deviceCode)), config.Auth.DeviceCodeTTL); err != nil {
+		return deviceError(c, http.StatusInternalServerError, "server_error")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"device_code":               deviceCode,
+		"user_code":                 formatUserCode(userCode),
+		"verification_uri":          config.Auth.DeviceVerificationURI,
+		"verification_uri_complete": config.Auth.DeviceVerificationURI + "?user_code=" + formatUserCode(userCode),
+		"expires_in":                int(config.Auth.DeviceCodeTTL.Seconds()),
+		"interval":                  int(config.Auth.DevicePollInterval.Seconds()),
+	})
+}
+
+// VerifyDevice (GET /device) is the verification URI opened in a browser.
+// With a valid user_code it shows what is asking to sign in; nothing happens
+// until the user explicitly approves, so a phishing link with
+// verification_uri_complete cannot ride on an existing Casdoor session.
+func VerifyDevice(c echo.Context) error {
+	userCode := normalizeUserCode(c.QueryParam("user_code"))
+	if userCode == "" {
+		return renderDevice(c, http.StatusOK, "", true)
+	}
+
+	_, auth, err := pendingDevice(c.Request().Context(), userCode)
+	if err != nil {
+		return renderDevice(c, http.StatusNotFound, "Unknown or expired code, try again.", true)
+	}
+
+	// Double-submit token in a SameSite=Strict cookie, a cross-site form cannot approve
+	csrf := secrets.Token()
+	c.SetCookie(tokenCookie(deviceConfirmCookie, csrf, "/device", time.Until(auth.ExpiresAt)))
+
+	var b strings.Builder
+	if err := deviceTemplate.Execute(&b, map[string]interface{}{
+		"Confirm": deviceConfirmation{
+			UserCode:  formatUserCode(userCode),
+			ClientID:  auth.ClientID,
+			RequestIP: auth.RequestIP,
+			CreatedAt: auth.CreatedAt,
+			CSRFToken: csrf,
+		},
+	}); err != nil {
+		return err
+	}
+	return c.HTML(http.StatusOK, b.String())
+}
+
+// ConfirmDevice (POST /device) takes the user's decision from the confirmation
+// page: approve continues with the Casdoor login, deny ends the device request
+func ConfirmDevice(c echo.Context) error {
+	cookie, err := c.Cookie(deviceConfirmCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(c.FormValue("csrf_token"))) != 1 {
+		return renderDevice(c, http.StatusForbidden, "This confirmation expired, enter the code again.", true)
+	}
+	c.SetCookie(tokenCookie(deviceConfirmCookie, "", "/device", -time.Second))
+
+	hash, _, err := pendingDevice(c.Request().Context(), normalizeUserCode(c.FormValue("user_code")))
+	if err != nil {
+		return renderDevice(c, http.StatusNotFound, "Unknown or expired code, try again.", true)
+	}
+
+	switch c.FormValue("action") {
+	case "approve":
+		url, err := startLogin(c, loginState{DeviceCode: hash})
+		if err != nil {
+			return renderDevice(c, http.StatusInternalServerError, "Failed to start login.", false)
+		}
+		return c.Redirect(http.StatusFound, url)
+	case "deny":
+		return denyDevice(c, hash)
+	}
+	return renderDevice(c, http.StatusBadRequest, "Choose approve or deny.", false)
+}
+
+// pendingDevice looks up a device request by user code
+func pendingDevice(ctx context.Context, userCode string) (string, *deviceAuthorization, error) {
+	if userCode == "" {
+		return "", nil, store.ErrNotFound
+	}
+	hash, err := config.Store.Get(ctx, deviceUserKeyPrefix+userCode)
+	if err != nil {
+		return "", nil, err
+	}
+	auth, err := loadDevice(ctx, string(hash))
+	if err != nil {
+		return "", nil, err
+	}
+	if auth.Status != deviceStatusPending || time.Now().After(auth.ExpiresAt) {
+		return "", nil, store.ErrNotFound
+	}
+	return string(hash), auth, nil
+}
+
+// DeviceToken (POST /auth/device/token) is polled by the device until the user
+// approved or denied the request
+func DeviceToken(c echo.Context) error {
+	grantType := c.FormValue("grant_type")
+	deviceCode := c.FormValue("device_code")
+	if grantType != deviceGrantType || deviceCode == "" {
+		return deviceError(c, http.StatusBadRequest, "invalid_request")
+	}
+
+	ctx := c.Request().Context()
+	hash := secrets.Hash(deviceCode)
+	auth, err := loadDevice(ctx, hash)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && time.Now().After(auth.ExpiresAt)) {
+		return deviceError(c, http.StatusBadRequest, "expired_token")
+	}
+	if err != nil {
+		return deviceError(c, http.StatusInternalServerError, "server_error")
+	}
+
+	switch auth.Status {
+	case deviceStatusDenied:
+		_ = config.Store.Delete(ctx, deviceKeyPrefix+hash)
+		return deviceError(c, http.StatusBadRequest, "access_denied")
+
+	case deviceStatusApproved:
+		// Tokens are handed out exactly once, also to concurrent polls
+		picked, err := config.Store.SetNX(ctx, devicePickedKeyPrefix+hash, []byte("1"), time.Until(auth.ExpiresAt))
+		if err != nil {
+			return deviceError(c, http.StatusInternalServerError, "server_error")
+		}
+		if !picked {
+			return deviceError(c, http.StatusBadRequest, "expired_token")
+		}
+		_ = config.Store.Delete(ctx, deviceKeyPrefix+hash)
+		_ = config.Store.Delete(ctx, devicePollKeyPrefix+hash)
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"access_token":  auth.Token.AccessToken,
+			"token_type":    "Bearer",
+			"expires_in":    int(time.Until(auth.Token.Expiry).Seconds()),
+			"refresh_token": auth.Token.RefreshToken,
+			"id_token":      auth.IDToken,
+		})
+	}
+
+	// Still pending, enforce the polling interval (RFC 8628 section 3.5)
+	poll := devicePoll{Interval: config.Auth.DevicePollInterval}
+	if data, err := config.Store.Get(ctx, devicePollKeyPrefix+hash); err == nil {
+		_ = json.Unmarshal(data, &poll)
+	}
+	now := time.Now()
+	tooFast := !poll.LastPoll.IsZero() && now.Sub(poll.LastPoll) < poll.Interval
+	poll.LastPoll = now
+	if tooFast {
+		poll.Interval += 5 * time.Second
+	}
+	data, err := json.Marshal(poll)
+	if err == nil {
+		err = config.Store.Set(ctx, devicePollKeyPrefix+hash, data, time.Until(auth.ExpiresAt))
+	}
+	if err != nil {
+		return deviceError(c, http.StatusInternalServerError, "server_error")
+	}
+
+	if tooFast {
+		return deviceError(c, http.StatusBadRequest, "slow_down")
+	}
+	return deviceError(c, http.StatusBadRequest, "authorization_pending")
+}
+
+// approveDevice is called by HandleCallback once the user logged in for a device
+func approveDevice(c echo.Context, hash string, token *oauth2.Token) error {
+	ctx := c.Request().Context()
+	auth, err := loadDevice(ctx, hash)
+	if err != nil || auth.Status != deviceStatusPending {
+		return renderDevice(c, http.StatusBadRequest, "This device request expired or was already used.", false)
+	}
+
+	auth.Status = deviceStatusApproved
+	auth.Token = token
+	auth.IDToken, _ = token.Extra("id_token").(string)
+	if err := saveDevice(ctx, hash, auth); err != nil {
+		return renderDevice(c, http.StatusInternalServerError, "Failed to approve the device.", false)
+	}
+	_ = config.Store.Delete(ctx, deviceUserKeyPrefix+auth.UserCode)
+
+	return renderDevice(c, http.StatusOK, "Device approved, you can return to your terminal.", false)
+}
+
+// denyDevice marks the device request as denied when the user denies it or
+// cancels the login
+func denyDevice(c echo.Context, hash string) error {
+	ctx := c.Request().Context()
+	if auth, err := loadDevice(ctx, hash); err == nil && auth.Status == deviceStatusPending {
+		auth.Status = deviceStatusDenied
+		_ = saveDevice(ctx, hash, auth)
+		_ = config.Store.Delete(ctx, deviceUserKeyPrefix+auth.UserCode)
+	}
+	return renderDevice(c, http.StatusOK, "Device login denied.", false)
+}
+
+func loadDevice(ctx context.Context, hash string) (*deviceAuthorization, error) {
+	data, err := config.Store.Get(ctx, deviceKeyPrefix+hash)
+	if err != nil {
+		return nil, err
+	}
+
+	var auth deviceAuthorization
+	if err := json.Unmarshal(data, &auth); err != nil {
+		return nil, err
+	}
+	return &auth, nil
+}
+
+func saveDevice(ctx context.Context, hash string, auth *deviceAuthorization) error {
+	data, err := json.Marshal(auth)
+	if err != nil {
+		return err
+	}
+	return config.Store.Set(ctx, deviceKeyPrefix+hash, data, time.Until(auth.ExpiresAt))
+}
+
+func deviceError(c echo.Context, status int, code string) error {
+	return c.JSON(status, map[string]string{
+		"error": code,
+	})
+}
+
+func renderDevice(c echo.Context, status int, message string, form bool) error {
+	var b strings.Builder
+	if err := deviceTemplate.Execute(&b, map[string]interface{}{"Message": message, "Form": form}); err != nil {
+		return err
+	}
+	return c.HTML(status, b.String())
+}
+
+func newUserCode() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	for i := range b {
+		b[i] = userCodeAlphabet[int(b[i])%len(userCodeAlphabet)]
+	}
+	return string(b)
+}
+
+// formatUserCode shows the code as XXXX-XXXX
+func formatUserCode(code string) string {
+	return code[:4] + "-" + code[4:]
+}
+
+// normalizeUserCode accepts lower case, dashes and spaces in typed codes
+func normalizeUserCode(code string) string {
+	code = strings.ToUpper(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	return code
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/casdoor/casdoor-go-sdk/casdoorsdk"
+	"github.com/labstack/echo/v4"
+	"github.com/skyapps-id/casdoor-test/config"
+	"github.com/skyapps-id/casdoor-test/problem"
+)
+
+// roleUpdateAttempts bounds the read-modify-write retries of role assignment
+const roleUpdateAttempts = 3
+
+var (
+	errUserNotFound     = errors.New("user not found")
+	errConcurrentUpdate = errors.New("user was modified concurrently")
+)
+
+// userETag derives an ETag from the user's state. Sign-in bookkeeping and the
+// update timestamp are left out so logins do not invalidate it.
+func userETag(u *casdoorsdk.User) string {
+	state := *u
+	state.UpdatedTime = ""
+	state.IsOnline = false
+	state.LastSigninTime = ""
+	state.LastSigninIp = ""
+	state.LastSigninWrongTime = ""
+	state.SigninWrongTimes = 0
+	return etagOf(state)
+}
+
+func roleETag(r *casdoorsdk.Role) string {
+	return etagOf(r)
+}
+
+func etagOf(v interface{}) string {
+	data, _ := json.Marshal(v)
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// preconditionFailed checks If-Match against the current ETag. When the check
+// fails the 412/428 response is written and true is returned.
+func preconditionFailed(c echo.Context, current string) (bool, error) {
+	ifMatch := c.Request().Header.Get("If-Match")
+	if ifMatch == "" {
+		if config.API.RequireIfMatch && c.Request().Method != http.MethodPost {
+			return true, preconditionRequired()
+		}
+		return false, nil
+	}
+	if etagMatches(ifMatch, current) {
+		return false, nil
+	}
+
+	c.Response().Header().Set("ETag", current)
+	return true, problem.New(http.StatusPreconditionFailed, problem.CodePreconditionFailed, "Resource was modified, reload it and retry")
+}
+
+// ifMatchHeader returns If-Match for routes that check it later on (role
+// membership), answering 428 when it is missing and REQUIRE_IF_MATCH is on
+func ifMatchHeader(c echo.Context) (string, error) {
+	ifMatch := c.Request().Header.Get("If-Match")
+	if ifMatch == "" && config.API.RequireIfMatch {
+		return "", preconditionRequired()
+	}
+	return ifMatch, nil
+}
+
+// etagMatches reports whether an If-Match value (a list of ETags or "*")
+// matches current. Weak tags compare by their value, proxies weaken ETags
+// when they compress responses.
+func etagMatches(ifMatch, current string) bool {
+	for _, tag := range strings.Split(ifMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == current {
+			return true
+		}
+	}
+	return false
+}
+
+func preconditionRequired() *problem.Error {
+	return problem.New(http.StatusPreconditionRequired, problem.CodePreconditionRequired, "If-Match header is required, read the resource first to get its ETag")
+}
+
+// updateUserRoles runs a read-modify-write of the user's roles, retrying when
+// the user changes between the read and the write. ifMatch, when set, must
+// match the user's state on the first read. When mutate reports no change
+// nothing is written and changed is false.
+func updateUserRoles(username, ifMatch string, mutate func(user *casdoorsdk.User) bool) (before, after *casdoorsdk.User, changed bool, err error) {
+	for attempt := 0; attempt < roleUpdateAttempts; attempt++ {
+		user, err := config.CasdoorClient.GetUser(username)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		if user == nil {
+			return nil, nil, false, errUserNotFound
+		}
+
+		tag := userETag(user)
+		if attempt == 0 && ifMatch != "" && !etagMatches(ifMatch, tag) {
+			return user, nil, false, errConcurrentUpdate
+		}
+
+		snapshot := *user
+		if !mutate(user) {
+			return &snapshot, &snapshot, false, nil
+		}
+
+		// Casdoor has no conditional update, re-read right before writing
+		current, err := config.CasdoorClient.GetUser(username)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		if current == nil {
+			return nil, nil, false, errUserNotFound
+		}
+		if userETag(current) != tag {
+			if ifMatch != "" {
+				return current, nil, false, errConcurrentUpdate
+			}
+			continue
+		}
+
+		affected, err := config.CasdoorClient.UpdateUser(user)
+		return &snapshot, user, true, mutationError(affected, err)
+	}
+	return nil, nil, false, errConcurrentUpdate
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/casdoor/casdoor-go-sdk/casdoorsdk"
+)
+
+func TestEtagMatches(t *testing.T) {
+	const current = `"abc"`
+	tests := []struct {
+		ifMatch string
+		want    bool
+	}{
+		{`"abc"`, true},
+		{`"def"`, false},
+		{`*`, true},
+		{`W/"abc"`, true},
+		{`"def", "abc"`, true},
+		{`"def",W/"abc"`, true},
+		{`"def", "ghi"`, false},
+		{`abc`, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.ifMatch, current); got != tt.want {
+			t.Errorf("etagMatches(%s) = %v, want %v", tt.ifMatch, got, tt.want)
+		}
+	}
+}
+
+func TestUserETag(t *testing.T) {
+	user := &casdoorsdk.User{Name: "alice", DisplayName: "Alice"}
+	tag := userETag(user)
+
+	signedIn := *user
+	signedIn.LastSigninTime = "2026-10-19T10:00:00Z"
+	signedIn.LastSigninIp = "10.0.0.1"
+	signedIn.IsOnline = true
+	signedIn.UpdatedTime = "2026-10-19T10:00:00Z"
+	if got := userETag(&signedIn); got != tag {
+		t.Errorf("userETag changed on sign-in: %s, want %s", got, tag)
+	}
+
+	renamed := *user
+	renamed.DisplayName = "Alice B."
+	if got := userETag(&renamed); got == tag {
+		t.Errorf("userETag did not change with the display name")
+	}
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/casdoor/casdoor-go-sdk/casdoorsdk"
+	"github.com/skyapps-id/casdoor-test/config"
+	"github.com/skyapps-id/casdoor-test/rolegrant"
+)
+
+// grantReaperActor is the change log actor of automatic grant expiry
+const grantReaperActor = "system:role-grant-reaper"
+
+var (
+	errPermanentRole = errors.New("role is already assigned without expiry")
+	errGrantStore    = errors.New("role grant store failed")
+)
+
+// assignRole grants a role on both sides, permanently or until expiresAt, and
+// records the change. A temporary grant is kept in the store for the reaper;
+// assigning again moves the expiry or, without expiresAt, makes it permanent.
+func assignRole(ctx context.Context, cc changeContext, username, roleName, ifMatch string, expiresAt *time.Time) (*casdoorsdk.User, *rolegrant.Grant, bool, error) {
+	existing, err := rolegrant.Get(ctx, config.Store, username, roleName)
+	if err != nil && !errors.Is(err, rolegrant.ErrNotFound) {
+		return nil, nil, false, fmt.Errorf("%w: %v", errGrantStore, err)
+	}
+
+	before, after, changed, err := changeMembership(username, roleName, ifMatch, true)
+	if err != nil {
+		if !rejectedBeforeWrite(err) {
+			cc.record("user.assign_role", "user", username, roleSnapshot(before), roleSnapshot(after), err)
+		}
+		return nil, nil, false, err
+	}
+	if !changed && existing == nil && expiresAt != nil {
+		return nil, nil, false, errPermanentRole
+	}
+
+	var grant *rolegrant.Grant
+	if expiresAt != nil {
+		grant = &rolegrant.Grant{
+			Username:  username,
+			Role:      roleName,
+			GrantedBy: cc.actor,
+			CreatedAt: time.Now().UTC(),
+			ExpiresAt: expiresAt.UTC(),
+		}
+		if err := rolegrant.Save(ctx, config.Store, grant); err != nil {
+			// Never leave a temporary role behind without its expiry
+			if changed {
+				_, _, _, _ = changeMembership(username, roleName, "", false)
+				cc.record("user.assign_role", "user", username, roleSnapshot(before), roleSnapshot(before), err)
+			}
+			return nil, nil, false, fmt.Errorf("%w: %v", errGrantStore, err)
+		}
+		changed = true
+	} else if existing != nil {
+		if err := rolegrant.Delete(ctx, config.Store, username, roleName); err != nil {
+			return nil, nil, false, fmt.Errorf("%w: %v", errGrantStore, err)
+		}
+		changed = true
+	}
+
+	if changed {
+		cc.record("user.assign_role", "user", username, grantSnapshot(before, existing), grantSnapshot(after, grant), nil)
+	}
+	return after, grant, changed, nil
+}
+
+// StartGrantReaper removes expired temporary role grants from Casdoor every
+// interval until ctx is done. Running it on several instances is harmless,
+// removing a role that is already gone changes nothing.
+func StartGrantReaper(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				reapExpiredGrants(ctx)
+			}
+		}
+	}()
+}
+
+func reapExpiredGrants(ctx context.Context) {
+	now := time.Now()
+	grants, err := rolegrant.Expired(ctx, config.Store, now)
+	if err != nil {
+		log.Printf("role grants: failed to list expired grants: %v", err)
+		return
+	}
+
+	cc := changeContext{actor: grantReaperActor}
+	for _, grant := range grants {
+		// assignRole may have renewed the grant since it was listed
+		expired, err := rolegrant.StillExpired(ctx, config.Store, grant, now)
+		if err != nil {
+			log.Printf("role grants: failed to reload grant %s/%s: %v", grant.Username, grant.Role, err)
+			continue
+		}
+		if !expired {
+			continue
+		}
+
+		before, after, changed, err := changeMembership(grant.Username, grant.Role, "", false)
+		if errors.Is(err, errUserNotFound) || errors.Is(err, errRoleNotFound) {
+			// Nothing left to take away
+			err = nil
+		}
+		if changed || err != nil {
+			cc.record("user.role_grant_expired", "user", grant.Username, grantSnapshot(before, grant), roleSnapshot(after), err)
+		}
+		if err != nil {
+			// Kept, the next run tries again
+			log.Printf("role grants: failed to remove %s from %s: %v", grant.Role, grant.Username, err)
+			continue
+		}
+
+		deleted, err := rolegrant.DeleteIfUnchanged(ctx, config.Store, grant)
+		if err != nil {
+			log.Printf("role grants: failed to delete grant %s/%s: %v", grant.Username, grant.Role, err)
+			continue
+		}
+		if !deleted && changed {
+			// Renewed while the role was being removed, give it back
+			_, restored, _, err := changeMembership(grant.Username, grant.Role, "", true)
+			cc.record("user.role_grant_expired", "user", grant.Username, roleSnapshot(after), roleSnapshot(restored), err)
+			if err != nil {
+				log.Printf("role grants: %s/%s was renewed during removal, restoring the role failed: %v", grant.Username, grant.Role, err)
+			}
+		}
+	}
+}
+
+// roleEntries lists the user's roles with display names and, for temporary
+// grants, their expiry
+func roleEntries(ctx context.Context, user *casdoorsdk.User) []map[string]interface{} {
+	grants, err := rolegrant.ForUser(ctx, config.Store, user.Name)
+	if err != nil {
+		log.Printf("role grants: failed to load grants of %s: %v", user.Name, err)
+	}
+
+	roles := make([]map[string]interface{}, 0, len(user.Roles))
+	for _, role := range user.Roles {
+		entry := map[string]interface{}{
+			"name":         role.Name,
+			"display_name": roleDisplayName(role),
+		}
+		if grant, ok := grants[role.Name]; ok {
+			entry["expires_at"] = grant.ExpiresAt
+		}
+		roles = append(roles, entry)
+	}
+	return roles
+}
+
+// grantSnapshot is roleSnapshot plus the expiry of a temporary grant
+func grantSnapshot(user *casdoorsdk.User, grant *rolegrant.Grant) map[string]interface{} {
+	snapshot := roleSnapshot(user)
+	if snapshot != nil && grant != nil {
+		snapshot["expires_at"] = grant.ExpiresAt
+	}
+	return snapshot
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/casdoor/casdoor-go-sdk/casdoorsdk"
+	"github.com/labstack/echo/v4"
+	"github.com/skyapps-id/casdoor-test/config"
+	"github.com/skyapps-id/casdoor-test/impersonation"
+	"github.com/skyapps-id/casdoor-test/problem"
+)
+
+// ImpersonateUser issues a short-lived token that acts as :username on behalf
+// of the calling admin. Requests made with it are authorized as the target,
+// sensitive actions stay blocked and audit records name the admin.
+func ImpersonateUser(c echo.Context) error {
+	actor, ok := c.Get("casdoorUser").(*casdoorsdk.User)
+	if !ok || actor == nil {
+		return problem.Unauthorized("Unauthorized")
+	}
+	// Only a human login may impersonate, and never from a delegated token
+	if method, _ := c.Get("authMethod").(string); (method != "" && method != "session") || actor.Type == "service-account" {
+		return problem.Forbidden("Impersonation requires an interactive login")
+	}
+
+	username := c.Param("username")
+	if username == actor.Name {
+		return problem.BadRequest("Cannot impersonate yourself")
+	}
+
+	var req struct {
+		Reason    string `json:"reason" validate:"required,max=500"`
+		ExpiresIn int    `json:"expires_in" validate:"min=0"` // seconds
+	}
+	if err := c.Bind(&req); err != nil {
+		return problem.BadRequest("Invalid request")
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(err)
+	}
+
+	ttl := config.Auth.ImpersonationTTL
+	if req.ExpiresIn > 0 {
+		ttl = time.Duration(req.ExpiresIn) * time.Second
+	}
+	if ttl > config.Auth.ImpersonationTTL {
+		return problem.BadRequest("expires_in exceeds the maximum of " + config.Auth.ImpersonationTTL.String())
+	}
+
+	target, err := config.CasdoorClient.GetUser(username)
+	if err != nil {
+		return problem.Casdoor(err, "Failed to get user")
+	}
+	if target == nil {
+		return problem.NotFound("User not found")
+	}
+
+	grant, token, err := impersonation.Create(c.Request().Context(), config.Store, actor.Name, username, req.Reason, ttl)
+	var after interface{}
+	if grant != nil {
+		after = map[string]interface{}{
+			"impersonation_id": grant.ID,
+			"reason":           grant.Reason,
+			"expires_at":       grant.ExpiresAt,
+		}
+	}
+	recordChange(c, "user.impersonate", "user", username, nil, after, err)
+	if err != nil {
+		return problem.Internal("Failed to start impersonation", err)
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"token":      token,
+		"token_type": "Bearer",
+		"subject":    grant.Subject,
+		"actor":      grant.Actor,
+		"expires_at": grant.ExpiresAt,
+		"message":    "Store this token now, it will not be shown again",
+	})
+}
@@ -0,0 +1,319 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"github.com/casdoor/casdoor-go-sdk/casdoorsdk"
+	"github.com/labstack/echo/v4"
+	"github.com/skyapps-id/casdoor-test/config"
+	"github.com/skyapps-id/casdoor-test/internal/secrets"
+	"github.com/skyapps-id/casdoor-test/problem"
+	"github.com/skyapps-id/casdoor-test/userimport"
+	"github.com/skyapps-id/casdoor-test/validation"
+)
+
+// Background jobs are written to the store every importCheckpointRows rows
+const importCheckpointRows = 25
+
+// ImportUsers creates users in bulk from a CSV or NDJSON file, sent as the
+// request body or as the multipart field "file". Every row is validated before
+// anything is created; ?dry_run=true stops after validation. Files above
+// IMPORT_ASYNC_ROWS rows (or ?async=true) run as a background job polled at
+// GET /api/users/import/:id.
+func ImportUsers(c echo.Context) error {
+	dryRun, err := strconv.ParseBool(defaultString(c.QueryParam("dry_run"), "false"))
+	if err != nil {
+		return problem.BadRequest("Invalid dry_run")
+	}
+	async, err := strconv.ParseBool(defaultString(c.QueryParam("async"), "false"))
+	if err != nil {
+		return problem.BadRequest("Invalid async")
+	}
+
+	body, format, err := importSource(c)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	rows, err := userimport.Parse(body, format, config.API.ImportMaxRows)
+	if errors.Is(err, userimport.ErrTooManyRows) {
+		return problem.New(http.StatusRequestEntityTooLarge, problem.CodeTooLarge,
+			fmt.Sprintf("Imports are limited to %d rows", config.API.ImportMaxRows))
+	}
+	if err != nil {
+		return problem.BadRequest("Invalid import file: " + err.Error())
+	}
+
+	results, err := validateImport(rows)
+	if err != nil {
+		return problem.Casdoor(err, "Failed to check existing users and roles")
+	}
+	invalid := []userimport.Result{}
+	for _, result := range results {
+		if result.Status == userimport.RowInvalid {
+			invalid = append(invalid, result)
+		}
+	}
+	if len(invalid) > 0 {
+		return problem.New(http.StatusBadRequest, problem.CodeValidationFailed,
+			fmt.Sprintf("%d of %d rows are invalid, nothing was imported", len(invalid), len(rows))).
+			With("rows", invalid)
+	}
+	if dryRun {
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"dry_run": true,
+			"total":   len(rows),
+			"results": results,
+		})
+	}
+
+	cc := changeContextOf(c)
+	job := userimport.NewJob(cc.actor, rows)
+	create := func(ctx context.Context, row userimport.Row) error {
+		return importUser(cc, row)
+	}
+
+	if !async && len(rows) <= config.API.ImportAsyncRows {
+		job.Process(c.Request().Context(), rows, config.API.ImportConcurrency, create, nil)
+		return c.JSON(http.StatusOK, job)
+	}
+
+	// The job outlives the request, progress is polled from the store
+	ctx := context.Background()
+	if err := userimport.Save(ctx, config.Store, job, config.API.ImportJobTTL); err != nil {
+		return problem.Internal("Failed to start import", err)
+	}
+	accepted := map[string]interface{}{
+		"id":         job.ID,
+		"status":     job.Status,
+		"total":      job.Total,
+		"status_url": "/api/users/import/" + job.ID,
+	}
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("import %s: panic: %v\n%s", job.ID, r, debug.Stack())
+			}
+		}()
+		job.Process(ctx, rows, config.API.ImportConcurrency, create, func(j *userimport.Job) {
+			if j.Processed%importCheckpointRows == 0 {
+				saveImportJob(ctx, j)
+			}
+		})
+		saveImportJob(ctx, job)
+	}()
+
+	c.Response().Header().Set(echo.HeaderLocation, "/api/users/import/"+job.ID)
+	return c.JSON(http.StatusAccepted, accepted)
+}
+
+// GetImportJob returns the progress and per-row results of a background
+// import, only to the user who started it
+func GetImportJob(c echo.Context) error {
+	job, err := userimport.Get(c.Request().Context(), config.Store, c.Param("id"))
+	if errors.Is(err, userimport.ErrNotFound) {
+		return problem.NotFound("Import job not found")
+	}
+	if err != nil {
+		return problem.Internal("Failed to get import job", err)
+	}
+	if job.Actor != changeContextOf(c).actor {
+		return problem.NotFound("Import job not found")
+	}
+	return c.JSON(http.StatusOK, job)
+}
+
+// importSource picks the file and its format from the request: a multipart
+// "file" (format from its extension) or the raw body (format from Content-Type).
+// ?format=csv|ndjson overrides either.
+func importSource(c echo.Context) (io.ReadCloser, string, error) {
+	format := c.QueryParam("format")
+	mediaType, _, _ := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+
+	if mediaType == echo.MIMEMultipartForm {
+		header, err := c.FormFile("file")
+		if err != nil {
+			return nil, "", problem.BadRequest("Missing file field")
+		}
+		if format == "" {
+			switch strings.ToLower(filepath.Ext(header.Filename)) {
+			case ".csv":
+				format = userimport.FormatCSV
+			case ".ndjson", ".jsonl":
+				format = userimport.FormatNDJSON
+			}
+		}
+		if format == "" {
+			return nil, "", problem.BadRequest("Unknown file type, use .csv or .ndjson or set ?format=")
+		}
+		file, err := header.Open()
+		if err != nil {
+			return nil, "", problem.Internal("Failed to read upload", err)
+		}
+		return file, format, nil
+	}
+
+	if format == "" {
+		switch mediaType {
+		case "text/csv":
+			format = userimport.FormatCSV
+		case "application/x-ndjson", "application/jsonl":
+			format = userimport.FormatNDJSON
+		default:
+			return nil, "", problem.BadRequest("Send text/csv or application/x-ndjson, or set ?format=")
+		}
+	}
+	return c.Request().Body, format, nil
+}
+
+// validateImport checks every row before anything is written: field rules,
+// duplicates within the file, unknown roles and usernames taken in Casdoor
+func validateImport(rows []userimport.Row) ([]userimport.Result, error) {
+	roles, err := config.CasdoorClient.GetRoles()
+	if err != nil {
+		return nil, err
+	}
+	knownRoles := map[string]*casdoorsdk.Role{}
+	for _, role := range roles {
+		knownRoles[role.Name] = role
+	}
+
+	results := make([]userimport.Result, len(rows))
+	usernames := map[string]int{}
+	emails := map[string]int{}
+	for i := range rows {
+		row := &rows[i]
+		result := userimport.Result{Line: row.Line, Username: row.Username}
+
+		if err := config.Validator.Validate(row); err != nil {
+			var fields validation.Errors
+			if !errors.As(err, &fields) {
+				return nil, err
+			}
+			result.Fields = fields
+		}
+		if first, ok := usernames[row.Username]; ok && row.Username != "" {
+			result.Fields = append(result.Fields, duplicateField("username", rows[first].Line))
+		} else {
+			usernames[row.Username] = i
+		}
+		email := strings.ToLower(row.Email)
+		if first, ok := emails[email]; ok && email != "" {
+			result.Fields = append(result.Fields, duplicateField("email", rows[first].Line))
+		} else {
+			emails[email] = i
+		}
+		for _, name := range row.Roles {
+			switch role := knownRoles[name]; {
+			case role == nil:
+				result.Fields = append(result.Fields, validation.FieldError{
+					Field: "roles", Rule: "exists", Message: "role " + name + " does not exist",
+				})
+			case !role.IsEnabled:
+				result.Fields = append(result.Fields, validation.FieldError{
+					Field: "roles", Rule: "enabled", Message: "role " + name + " is disabled",
+				})
+			}
+		}
+		results[i] = result
+	}
+
+	// Usernames already taken, one Casdoor page in memory at a time
+	_, _, err = scanPages(func(p, size int) ([]*casdoorsdk.User, int, error) {
+		return config.CasdoorClient.GetPaginationUsers(p, size, map[string]string{})
+	}, func(u *casdoorsdk.User) bool {
+		if i, ok := usernames[u.Name]; ok {
+			results[i].Fields = append(results[i].Fields, validation.FieldError{
+				Field: "username", Rule: "unique", Message: "is already taken",
+			})
+		}
+		return false
+	}, page{Number: 1})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range results {
+		results[i].Status = userimport.RowValid
+		if len(results[i].Fields) > 0 {
+			results[i].Status = userimport.RowInvalid
+		}
+	}
+	return results, nil
+}
+
+func duplicateField(field string, line int) validation.FieldError {
+	return validation.FieldError{
+		Field:   field,
+		Rule:    "unique",
+		Message: fmt.Sprintf("duplicates line %d", line),
+	}
+}
+
+// importUser creates one user and grants its roles. Invited users get a random
+// password and must set their own through Casdoor's password reset.
+func importUser(cc changeContext, row userimport.Row) error {
+	user := &casdoorsdk.User{
+		Owner:       "skyapps",
+		Name:        row.Username,
+		DisplayName: row.DisplayName,
+		Email:       row.Email,
+		Password:    row.Password,
+	}
+	if row.Invite {
+		user.Password = secrets.Token()
+		user.NeedUpdatePassword = true
+	}
+
+	affected, err := config.CasdoorClient.AddUser(user)
+	err = mutationError(affected, err)
+	cc.record("user.create", "user", row.Username, nil, user, err)
+	if err != nil {
+		return importCreateError(row, err)
+	}
+
+	for _, role := range row.Roles {
+		before, after, changed, err := changeMembership(row.Username, role, "", true)
+		if changed || err != nil {
+			cc.record("user.assign_role", "user", row.Username, roleSnapshot(before), roleSnapshot(after), err)
+		}
+		if err != nil {
+			return fmt.Errorf("user created, assigning %s failed: %s", role, memberError(row.Username, role, err))
+		}
+	}
+	return nil
+}
+
+// importCreateError is the row error kept in the job results, which the
+// importer reads back; Casdoor's message only goes to the log
+func importCreateError(row userimport.Row, err error) error {
+	if errors.Is(err, errNotAffected) || problem.Casdoor(err, "").Status == http.StatusConflict {
+		return errors.New("user already exists")
+	}
+	log.Printf("import: creating %s (line %d) failed: %v", row.Username, row.Line, err)
+	return errors.New("creating the user failed")
+}
+
+func saveImportJob(ctx context.Context, job *userimport.Job) {
+	if err := userimport.Save(ctx, config.Store, job, config.API.ImportJobTTL); err != nil {
+		log.Printf("import %s: failed to save progress: %v", job.ID, err)
+	}
+}
+
+func defaultString(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/skyapps-id/casdoor-test/userimport"
+)
+
+func TestImportCreateError(t *testing.T) {
+	row := userimport.Row{Line: 2, Username: "alice"}
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{errNotAffected, "user already exists"},
+		{errors.New("Error 1062: Duplicate entry 'skyapps-alice' for key 'PRIMARY'"), "user already exists"},
+		{errors.New("Error 1406: Data too long for column 'display_name'"), "creating the user failed"},
+	}
+	for _, tt := range tests {
+		if got := importCreateError(row, tt.err).Error(); got != tt.want {
+			t.Errorf("importCreateError(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/skyapps-id/casdoor-test/config"
+	"github.com/skyapps-id/casdoor-test/impersonation"
+	"github.com/skyapps-id/casdoor-test/problem"
+	"github.com/skyapps-id/casdoor-test/revocation"
+	"github.com/skyapps-id/casdoor-test/session"
+)
+
+var logoutHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// Logout revokes the caller's tokens and clears the token and session cookies.
+// The access token comes from the Authorization header or the session, the
+// refresh token from the JSON body, the refresh_token cookie or the session.
+// With ?redirect=true and an allowlisted post_logout_redirect_uri the browser
+// is sent to Casdoor's end-session endpoint, which also ends the Casdoor SSO
+// session.
+func Logout(c echo.Context) error {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	_ = c.Bind(&req)
+	if req.RefreshToken == "" {
+		if cookie, err := c.Cookie(refreshCookieName); err == nil {
+			req.RefreshToken = cookie.Value
+		}
+	}
+
+	accessToken := ""
+	if auth := c.Request().Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		accessToken = auth[7:]
+	}
+
+	// Impersonation tokens are ours, ending one leaves the admin's own login alone
+	if strings.HasPrefix(accessToken, impersonation.Prefix) {
+		err := impersonation.Revoke(c.Request().Context(), config.Store, accessToken)
+		if err != nil && !errors.Is(err, impersonation.ErrInvalidToken) {
+			return problem.Internal("Failed to end impersonation", err)
+		}
+		return c.JSON(http.StatusOK, map[string]string{
+			"message": "Impersonation ended",
+		})
+	}
+
+	redirect := c.QueryParam("redirect") == "true"
+	redirectURI := c.QueryParam("post_logout_redirect_uri")
+	if redirect {
+		if _, err := c.Cookie(session.CookieName); accessToken == "" && err != nil {
+			return problem.BadRequest("Bearer token or session required for end-session redirect")
+		}
+		if redirectURI == "" || strings.HasPrefix(redirectURI, "/") || !config.IsAllowedReturnTo(redirectURI) {
+			return problem.BadRequest("post_logout_redirect_uri is not allowed")
+		}
+	}
+
+	// Session mode keeps the tokens server-side
+	if sess := endSession(c); sess != nil {
+		if accessToken == "" {
+			accessToken = sess.AccessToken
+		}
+		if req.RefreshToken == "" {
+			req.RefreshToken = sess.RefreshToken
+		}
+	}
+
+	ctx := c.Request().Context()
+
+	// Deny list first so the token stops working on every instance right away
+	if accessToken != "" {
+		if claims, err := config.CasdoorClient.ParseJwtToken(accessToken); err == nil && claims.ID == "" {
+			// Nothing to put on the deny list, the token stays valid until it expires
+			log.Printf("Logout of %s: access token has no jti, not revoked", claims.User.Name)
+		} else if err == nil {
+			expiresAt := time.Time{}
+			if claims.ExpiresAt != nil {
+				expiresAt = claims.ExpiresAt.Time
+			}
+			if err := revocation.Revoke(ctx, config.Store, claims.ID, expiresAt); err != nil {
+				return problem.Internal("Failed to revoke token", err)
+			}
+		}
+	}
+
+	if req.RefreshToken != "" {
+		if err := revokeRefreshFamily(ctx, req.RefreshToken); err != nil {
+			log.Printf("Failed to revoke refresh token family: %v", err)
+		}
+	}
+
+	clearTokenCookies(c)
+
+	if redirect && accessToken != "" {
+		// Casdoor expires the token itself while handling the end-session request
+		return c.Redirect(http.StatusFound, endSessionURL(accessToken, redirectURI))
+	}
+
+	casdoorRevoked := false
+	if accessToken != "" {
+		if err := revokeAtCasdoor(ctx, accessToken); err != nil {
+			log.Printf("Failed to revoke token at Casdoor: %v", err)
+		} else {
+			casdoorRevoked = true
+		}
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":         "Logged out successfully",
+		"casdoor_revoked": casdoorRevoked,
+	})
+}
+
+func endSessionURL(accessToken, redirectURI string) string {
+	params := url.Values{}
+	params.Set("id_token_hint", accessToken)
+	params.Set("post_logout_redirect_uri", redirectURI)
+	return config.CasdoorClient.Endpoint + "/api/logout?" + params.Encode()
+}
+
+// revokeAtCasdoor expires the token server-side through Casdoor's logout endpoint
+func revokeAtCasdoor(ctx context.Context, accessToken string) error {
+	params := url.Values{}
+	params.Set("id_token_hint", accessToken)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		config.CasdoorClient.Endpoint+"/api/logout?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := logoutHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Status string `json:"status"`
+		Msg    string `json:"msg"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return err
+	}
+	if body.Status != "ok" {
+		return fmt.Errorf("casdoor: %s", body.Msg)
+	}
+	return nil
+}
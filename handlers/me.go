@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/casdoor/casdoor-go-sdk/casdoorsdk"
+	"github.com/labstack/echo/v4"
+	"github.com/skyapps-id/casdoor-test/config"
+	"github.com/skyapps-id/casdoor-test/problem"
+)
+
+// GetCurrentUser returns the profile of the authenticated user, with roles,
+// permissions and MFA status
+func GetCurrentUser(c echo.Context) error {
+	user, ok := c.Get("casdoorUser").(*casdoorsdk.User)
+	if !ok || user == nil {
+		return problem.Unauthorized("Unauthorized")
+	}
+
+	roles := roleEntries(c.Request().Context(), user)
+
+	profile := map[string]interface{}{
+		"username":       user.Name,
+		"display_name":   user.DisplayName,
+		"first_name":     user.FirstName,
+		"last_name":      user.LastName,
+		"email":          user.Email,
+		"email_verified": user.EmailVerified,
+		"phone":          user.Phone,
+		"avatar":         user.Avatar,
+		"organization":   user.Owner,
+		"type":           user.Type,
+		"roles":          roles,
+		"permissions":    userPermissions(user),
+		"groups":         user.Groups,
+		"mfa":            mfaStatus(user),
+		"created_at":     user.CreatedTime,
+		"auth_method":    c.Get("authMethod"),
+	}
+	if impersonator, ok := c.Get("impersonator").(string); ok {
+		profile["impersonated_by"] = impersonator
+	}
+
+	if user.Type != "service-account" {
+		c.Response().Header().Set("ETag", userETag(user))
+	}
+	return c.JSON(http.StatusOK, profile)
+}
+
+// UpdateCurrentUser changes the self-editable profile fields of the
+// authenticated user. Email, roles and everything else are admin-only.
+func UpdateCurrentUser(c echo.Context) error {
+	user, ok := c.Get("casdoorUser").(*casdoorsdk.User)
+	if !ok || user == nil {
+		return problem.Unauthorized("Unauthorized")
+	}
+	if user.Type == "service-account" {
+		return problem.Forbidden("Service accounts have no editable profile")
+	}
+
+	var req struct {
+		DisplayName *string `json:"display_name"`
+		FirstName   *string `json:"first_name"`
+		LastName    *string `json:"last_name"`
+		Avatar      *string `json:"avatar"`
+		Phone       *string `json:"phone"`
+		CountryCode *string `json:"country_code"`
+		Region      *string `json:"region"`
+		Location    *string `json:"location"`
+		Language    *string `json:"language"`
+		Bio         *string `json:"bio"`
+		Homepage    *string `json:"homepage"`
+	}
+	dec := json.NewDecoder(c.Request().Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		return problem.BadRequest("Invalid request, only profile fields can be changed: " + err.Error())
+	}
+
+	// Start from Casdoor's current state, only the listed columns are written
+	before, err := config.CasdoorClient.GetUser(user.Name)
+	if err != nil {
+		return problem.Casdoor(err, "Failed to get user info")
+	}
+	if before == nil {
+		return problem.NotFound("User not found")
+	}
+	updated := *before
+
+	columns := []string{}
+	set := func(column string, value *string, field *string) {
+		if value != nil {
+			*field = *value
+			columns = append(columns, column)
+		}
+	}
+	set("display_name", req.DisplayName, &updated.DisplayName)
+	set("first_name", req.FirstName, &updated.FirstName)
+	set("last_name", req.LastName, &updated.LastName)
+	set("avatar", req.Avatar, &updated.Avatar)
+	set("phone", req.Phone, &updated.Phone)
+	set("country_code", req.CountryCode, &updated.CountryCode)
+	set("region", req.Region, &updated.Region)
+	set("location", req.Location, &updated.Location)
+	set("language", req.Language, &updated.Language)
+	set("bio", req.Bio, &updated.Bio)
+	set("homepage", req.Homepage, &updated.Homepage)
+
+	if len(columns) == 0 {
+		return problem.BadRequest("No fields to update")
+	}
+
+	affected, err := config.CasdoorClient.UpdateUserForColumns(&updated, columns)
+	recordChange(c, "user.update_self", "user", user.Name, before, &updated, mutationError(affected, err))
+	if err != nil || !affected {
+		return upstreamError(affected, err, "Failed to update profile", nil)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "Profile updated successfully",
+		"updated": columns,
+	})
+}
+
+// ChangePassword sets a new password for the authenticated user. Casdoor
+// checks the old password.
+func ChangePassword(c echo.Context) error {
+	user, ok := c.Get("casdoorUser").(*casdoorsdk.User)
+	if !ok || user == nil {
+		return problem.Unauthorized("Unauthorized")
+	}
+	if user.Type == "service-account" {
+		return problem.Forbidden("Service accounts have no password")
+	}
+
+	var req struct {
+		OldPassword string `json:"old_password" validate:"required"`
+		NewPassword string `json:"new_password" validate:"required,password"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return problem.BadRequest("Invalid request")
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(err)
+	}
+	if req.OldPassword == req.NewPassword {
+		return problem.BadRequest("New password must differ from the old one")
+	}
+
+	changed, err := config.CasdoorClient.SetPassword(user.Owner, user.Name, req.OldPassword, req.NewPassword)
+	recordChange(c, "user.change_password", "user", user.Name, nil, nil, mutationError(changed, err))
+	if isWrongPassword(err) {
+		return problem.BadRequest("Old password is incorrect").Wrap(err)
+	}
+	if err != nil {
+		return problem.Casdoor(err, "Failed to change password")
+	}
+	if !changed {
+		return problem.Internal("Failed to change password", errNotAffected)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Password changed successfully",
+	})
+}
+
+// isWrongPassword recognizes Casdoor's answer to a wrong old password, which
+// only comes as a message ("The old password is wrong", "password or code is
+// incorrect" depending on the version)
+func isWrongPassword(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "password") && (strings.Contains(msg, "wrong") || strings.Contains(msg, "incorrect"))
+}
+
+// roleDisplayName falls back to Casdoor when the user's role entry is not expanded
+func roleDisplayName(role *casdoorsdk.Role) string {
+	if role.DisplayName != "" {
+		return role.DisplayName
+	}
+	full, err := config.CasdoorClient.GetRole(role.Name)
+	if err != nil || full == nil || full.DisplayName == "" {
+		return role.Name
+	}
+	return full.DisplayName
+}
+
+// userPermissions merges the permissions granted directly and through roles
+func userPermissions(user *casdoorsdk.User) []map[string]interface{} {
+	byName := map[string]*casdoorsdk.Permission{}
+	for _, p := range user.Permissions {
+		byName[p.Name] = p
+	}
+	for _, role := range user.Roles {
+		perms, err := config.CasdoorClient.GetPermissionsByRole(role.Name)
+		if err != nil {
+			log.Printf("Failed to get permissions of role %s: %v", role.Name, err)
+			continue
+		}
+		for _, p := range perms {
+			byName[p.Name] = p
+		}
+	}
+
+	permissions := make([]map[string]interface{}, 0, len(byName))
+	for _, p := range byName {
+		permissions = append(permissions, map[string]interface{}{
+			"name":         p.Name,
+			"display_name": p.DisplayName,
+			"resources":    p.Resources,
+			"actions":      p.Actions,
+			"effect":       p.Effect,
+		})
+	}
+	sort.Slice(permissions, func(i, j int) bool {
+		return permissions[i]["name"].(string) < permissions[j]["name"].(string)
+	})
+	return permissions
+}
+
+func mfaStatus(user *casdoorsdk.User) map[string]interface{} {
+	methods := []string{}
+	if user.TotpSecret != "" {
+		methods = append(methods, "app")
+	}
+	if user.MfaPhoneEnabled {
+		methods = append(methods, "sms")
+	}
+	if user.MfaEmailEnabled {
+		methods = append(methods, "email")
+	}
+
+	return map[string]interface{}{
+		"enabled":   len(methods) > 0,
+		"methods":   methods,
+		"preferred": user.PreferredMfaType,
+	}
+}
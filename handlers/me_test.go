@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestIsWrongPassword(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("The old password is wrong"), true},
+		{errors.New("password or code is incorrect"), true},
+		{errors.New("the user: skyapps/alice doesn't exist"), false},
+		{errors.New("dial tcp: connection refused"), false},
+	}
+	for _, tt := range tests {
+		if got := isWrongPassword(tt.err); got != tt.want {
+			t.Errorf("isWrongPassword(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
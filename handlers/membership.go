@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"errors"
+	"slices"
+	"sync"
+
+	"github.com/casdoor/casdoor-go-sdk/casdoorsdk"
+	"github.com/skyapps-id/casdoor-test/config"
+)
+
+var (
+	errRoleNotFound = errors.New("role not found")
+	errRoleDisabled = errors.New("role is disabled")
+)
+
+// rejectedBeforeWrite reports whether a membership change failed before
+// anything was written to Casdoor
+func rejectedBeforeWrite(err error) bool {
+	return errors.Is(err, errUserNotFound) || errors.Is(err, errRoleNotFound) ||
+		errors.Is(err, errRoleDisabled) || errors.Is(err, errConcurrentUpdate)
+}
+
+// changeMembership adds or removes one role of a user on both sides of the
+// relation, the user's Roles and the role's Users. Granting needs an existing,
+// enabled role; removing also cleans up references to a deleted role. Sides
+// already in the wanted state are not written, so repeating a call is a no-op.
+func changeMembership(username, roleName, ifMatch string, add bool) (before, after *casdoorsdk.User, changed bool, err error) {
+	role, err := config.CasdoorClient.GetRole(roleName)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if add && role == nil {
+		return nil, nil, false, errRoleNotFound
+	}
+	if add && !role.IsEnabled {
+		return nil, nil, false, errRoleDisabled
+	}
+
+	before, after, changed, err = updateUserRoles(username, ifMatch, func(user *casdoorsdk.User) bool {
+		return setRole(user, roleName, add)
+	})
+	if err != nil {
+		return before, after, changed, err
+	}
+	if role == nil {
+		if !changed {
+			return before, after, false, errRoleNotFound
+		}
+		return before, after, true, nil
+	}
+
+	roleChanged, err := updateRoleUsers(roleName, after.Owner+"/"+username, add)
+	if err != nil && changed {
+		// Put the user back so both sides keep agreeing
+		if _, _, _, undoErr := updateUserRoles(username, "", func(user *casdoorsdk.User) bool {
+			return setRole(user, roleName, !add)
+		}); undoErr != nil {
+			err = errors.Join(err, undoErr)
+		}
+		return before, before, false, err
+	}
+	return before, after, changed || roleChanged, err
+}
+
+// roleLocks serializes this instance's writes to a role's Users, concurrent
+// import workers would otherwise keep overwriting each other's members
+var roleLocks sync.Map
+
+func lockRole(roleName string) func() {
+	mu, _ := roleLocks.LoadOrStore(roleName, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// updateRoleUsers adds or removes member ("owner/name") in the role's Users,
+// retrying like updateUserRoles when the role changes underneath
+func updateRoleUsers(roleName, member string, add bool) (bool, error) {
+	defer lockRole(roleName)()
+
+	for attempt := 0; attempt < roleUpdateAttempts; attempt++ {
+		role, err := config.CasdoorClient.GetRole(roleName)
+		if err != nil {
+			return false, err
+		}
+		if role == nil {
+			return false, errRoleNotFound
+		}
+
+		count := 0
+		for _, m := range role.Users {
+			if m == member {
+				count++
+			}
+		}
+		if (add && count == 1) || (!add && count == 0) {
+			return false, nil
+		}
+
+		tag := roleETag(role)
+		updated := *role
+		updated.Users = slices.DeleteFunc(slices.Clone(role.Users), func(m string) bool { return m == member })
+		if add {
+			updated.Users = append(updated.Users, member)
+		}
+
+		current, err := config.CasdoorClient.GetRole(roleName)
+		if err != nil {
+			return false, err
+		}
+		if current == nil {
+			return false, errRoleNotFound
+		}
+		if roleETag(current) != tag {
+			continue
+		}
+
+		affected, err := config.CasdoorClient.UpdateRole(&updated)
+		return true, mutationError(affected, err)
+	}
+	return false, errConcurrentUpdate
+}
+
+// setRole adds or removes roleName in the user's roles, dropping duplicate
+// entries, and reports whether the roles changed
+func setRole(user *casdoorsdk.User, roleName string, add bool) bool {
+	roles := []*casdoorsdk.Role{}
+	found := 0
+	for _, role := range user.Roles {
+		if role.Name == roleName {
+			found++
+			if !add || found > 1 {
+				continue
+			}
+		}
+		roles = append(roles, role)
+	}
+	if add && found == 0 {
+		roles = append(roles, &casdoorsdk.Role{Owner: "skyapps", Name: roleName})
+	}
+	user.Roles = roles
+	if add {
+		return found != 1
+	}
+	return found > 0
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/casdoor/casdoor-go-sdk/casdoorsdk"
+)
+
+func TestSetRole(t *testing.T) {
+	tests := []struct {
+		name        string
+		roles       []string
+		role        string
+		add         bool
+		want        []string
+		wantChanged bool
+	}{
+		{"add new", []string{"user"}, "admin", true, []string{"user", "admin"}, true},
+		{"add existing", []string{"user", "admin"}, "admin", true, []string{"user", "admin"}, false},
+		{"add drops duplicates", []string{"admin", "user", "admin"}, "admin", true, []string{"admin", "user"}, true},
+		{"remove existing", []string{"user", "admin"}, "admin", false, []string{"user"}, true},
+		{"remove missing", []string{"user"}, "admin", false, []string{"user"}, false},
+		{"remove every duplicate", []string{"admin", "user", "admin"}, "admin", false, []string{"user"}, true},
+		{"add to none", nil, "user", true, []string{"user"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &casdoorsdk.User{}
+			for _, name := range tt.roles {
+				user.Roles = append(user.Roles, &casdoorsdk.Role{Owner: "skyapps", Name: name})
+			}
+
+			changed := setRole(user, tt.role, tt.add)
+			got := []string{}
+			for _, role := range user.Roles {
+				got = append(got, role.Name)
+			}
+			if changed != tt.wantChanged || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("setRole(%v, %s, %v) = %v %v, want %v %v", tt.roles, tt.role, tt.add, got, changed, tt.want, tt.wantChanged)
+			}
+		})
+	}
+}
+
+func TestSameSet(t *testing.T) {
+	tests := []struct {
+		a, b []string
+		want bool
+	}{
+		{nil, nil, true},
+		{[]string{}, nil, true},
+		{[]string{"a", "b"}, []string{"b", "a"}, true},
+		{[]string{"a", "a", "b"}, []string{"a", "b"}, true},
+		{[]string{"a", "b"}, []string{"b", "b", "a"}, true},
+		{[]string{"a", "a"}, []string{"a", "b"}, false},
+		{[]string{"a"}, []string{"a", "b"}, false},
+		{[]string{"a", "b"}, []string{"a"}, false},
+		{[]string{"a"}, nil, false},
+	}
+	for _, tt := range tests {
+		if got := sameSet(tt.a, tt.b); got != tt.want {
+			t.Errorf("sameSet(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestMemberError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{errUserNotFound, "user not found"},
+		{fmt.Errorf("loading: %w", errConcurrentUpdate), "user is being modified concurrently"},
+		{errRoleDisabled, "role is disabled"},
+		{errors.New("Error 1406: Data too long for column 'roles' at row 1"), "update failed"},
+	}
+	for _, tt := range tests {
+		if got := memberError("alice", "admin", tt.err); got != tt.want {
+			t.Errorf("memberError(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/skyapps-id/casdoor-test/config"
+)
+
+// scanPageSize is the Casdoor page size used when filters have to be applied locally
+const scanPageSize = 100
+
+// page is a 1-based page request
+type page struct {
+	Number int
+	Size   int
+}
+
+func (p page) offset() int {
+	return (p.Number - 1) * p.Size
+}
+
+// parsePage reads ?page= and ?page_size=
+func parsePage(c echo.Context) (page, error) {
+	p := page{Number: 1, Size: config.API.DefaultPageSize}
+
+	if v := c.QueryParam("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return p, fmt.Errorf("page must be a positive integer")
+		}
+		p.Number = n
+	}
+	if v := c.QueryParam("page_size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > config.API.MaxPageSize {
+			return p, fmt.Errorf("page_size must be between 1 and %d", config.API.MaxPageSize)
+		}
+		p.Size = n
+	}
+	return p, nil
+}
+
+// parseSort maps ?sort=field or ?sort=-field to Casdoor's sortField/sortOrder.
+// allowed maps API field names to Casdoor columns.
+func parseSort(c echo.Context, allowed map[string]string, queryMap map[string]string) error {
+	sort := c.QueryParam("sort")
+	if sort == "" {
+		return nil
+	}
+
+	order := "ascend"
+	if strings.HasPrefix(sort, "-") {
+		order = "descend"
+		sort = sort[1:]
+	}
+	column, ok := allowed[sort]
+	if !ok {
+		fields := make([]string, 0, len(allowed))
+		for f := range allowed {
+			fields = append(fields, f)
+		}
+		slices.Sort(fields)
+		return fmt.Errorf("sort must be one of %s (prefix - for descending)", strings.Join(fields, ", "))
+	}
+
+	queryMap["sortField"] = column
+	queryMap["sortOrder"] = order
+	return nil
+}
+
+// setPaginationHeaders adds X-Total-Count and an RFC 8288 Link header
+func setPaginationHeaders(c echo.Context, p page, total int) {
+	c.Response().Header().Set("X-Total-Count", strconv.Itoa(total))
+
+	last := (total + p.Size - 1) / p.Size
+	if last < 1 {
+		last = 1
+	}
+
+	link := func(number int, rel string) string {
+		u := *c.Request().URL
+		q := u.Query()
+		q.Set("page", strconv.Itoa(number))
+		q.Set("page_size", strconv.Itoa(p.Size))
+		u.RawQuery = q.Encode()
+		return fmt.Sprintf(`<%s>; rel="%s"`, u.RequestURI(), rel)
+	}
+
+	links := []string{link(1, "first")}
+	if p.Number > 1 {
+		links = append(links, link(p.Number-1, "prev"))
+	}
+	if p.Number < last {
+		links = append(links, link(p.Number+1, "next"))
+	}
+	links = append(links, link(last, "last"))
+	c.Response().Header().Set("Link", strings.Join(links, ", "))
+}
+
+// scanPages walks every Casdoor page and applies match locally, returning the
+// requested page of matches and their total. Only one Casdoor page is held at a time.
+func scanPages[T any](fetch func(p, size int) ([]T, int, error), match func(T) bool, pg page) ([]T, int, error) {
+	items := []T{}
+	matched := 0
+	for p := 1; ; p++ {
+		batch, total, err := fetch(p, scanPageSize)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, item := range batch {
+			if !match(item) {
+				continue
+			}
+			if matched >= pg.offset() && len(items) < pg.Size {
+				items = append(items, item)
+			}
+			matched++
+		}
+		if len(batch) < scanPageSize || p*scanPageSize >= total {
+			return items, matched, nil
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestParseSort(t *testing.T) {
+	allowed := map[string]string{"username": "name", "created": "created_time", "email": "email"}
+
+	tests := []struct {
+		query   string
+		want    map[string]string
+		wantErr string
+	}{
+		{"", map[string]string{}, ""},
+		{"?sort=username", map[string]string{"sortField": "name", "sortOrder": "ascend"}, ""},
+		{"?sort=-created", map[string]string{"sortField": "created_time", "sortOrder": "descend"}, ""},
+		{"?sort=password", nil, "sort must be one of created, email, username (prefix - for descending)"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/users"+tt.query, nil)
+		c := echo.New().NewContext(req, httptest.NewRecorder())
+
+		queryMap := map[string]string{}
+		err := parseSort(c, allowed, queryMap)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("parseSort(%s) error = %v, want %q", tt.query, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(queryMap, tt.want) {
+			t.Errorf("parseSort(%s) = %v, %v, want %v", tt.query, queryMap, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/casdoor/casdoor-go-sdk/casdoorsdk"
+	"github.com/labstack/echo/v4"
+	"github.com/skyapps-id/casdoor-test/config"
+	"github.com/skyapps-id/casdoor-test/middleware"
+	"github.com/skyapps-id/casdoor-test/pat"
+	"github.com/skyapps-id/casdoor-test/problem"
+)
+
+// CreatePersonalToken mints a personal access token for the current user.
+// Every scope ("METHOD /path") must be allowed by the user's own role.
+func CreatePersonalToken(c echo.Context) error {
+	user, ok := c.Get("casdoorUser").(*casdoorsdk.User)
+	if !ok || user == nil {
+		return problem.Unauthorized("Unauthorized")
+	}
+	if c.Get("authMethod") == "pat" || user.Type == "service-account" {
+		return problem.Forbidden("Personal access tokens can only be created by an interactive user")
+	}
+
+	var req struct {
+		Name      string   `json:"name" validate:"required,max=100"`
+		Scopes    []string `json:"scopes" validate:"required,min=1,dive,required"`
+		ExpiresIn int      `json:"expires_in" validate:"min=0"` // seconds
+	}
+	if err := c.Bind(&req); err != nil {
+		return problem.BadRequest("Invalid request")
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(err)
+	}
+
+	ttl := config.Auth.PATDefaultTTL
+	if req.ExpiresIn > 0 {
+		ttl = time.Duration(req.ExpiresIn) * time.Second
+	}
+	if ttl > config.Auth.PATMaxTTL {
+		return problem.BadRequest("expires_in exceeds the maximum of " + config.Auth.PATMaxTTL.String())
+	}
+
+	for _, scope := range req.Scopes {
+		method, path, ok := pat.ParseScope(scope)
+		if !ok || method == "*" || path == "*" {
+			return problem.BadRequest("Invalid scope, expected \"METHOD /path\": " + scope)
+		}
+
+		allowed, _, err := middleware.Enforce(user, method, path)
+		if err != nil {
+			return problem.Internal("Failed to check scope", err)
+		}
+		if !allowed {
+			return problem.Forbidden("Scope exceeds your permissions: " + scope)
+		}
+	}
+
+	token, secret, err := pat.Create(c.Request().Context(), config.Store, user.Name, req.Name, req.Scopes, ttl)
+	if err != nil {
+		return problem.Internal("Failed to create token", err)
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"message": "Token created, store it now, it will not be shown again",
+		"token":   token.View(),
+		"secret":  secret,
+	})
+}
+
+func ListPersonalTokens(c echo.Context) error {
+	user, ok := c.Get("casdoorUser").(*casdoorsdk.User)
+	if !ok || user == nil {
+		return problem.Unauthorized("Unauthorized")
+	}
+
+	tokens, err := pat.List(c.Request().Context(), config.Store, user.Name)
+	if err != nil {
+		return problem.Internal("Failed to get tokens", err)
+	}
+
+	views := make([]pat.TokenView, 0, len(tokens))
+	for _, token := range tokens {
+		views = append(views, token.View())
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"tokens": views,
+		"total":  len(views),
+	})
+}
+
+func RevokePersonalToken(c echo.Context) error {
+	user, ok := c.Get("casdoorUser").(*casdoorsdk.User)
+	if !ok || user == nil {
+		return problem.Unauthorized("Unauthorized")
+	}
+
+	err := pat.Revoke(c.Request().Context(), config.Store, user.Name, c.Param("id"))
+	if errors.Is(err, pat.ErrNotFound) {
+		return problem.NotFound("Token not found")
+	}
+	if err != nil {
+		return problem.Internal("Failed to revoke token", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Token revoked successfully",
+	})
+}
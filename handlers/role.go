@@ -1,46 +1,142 @@
 package handlers
 
 import (
+	"errors"
+	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/casdoor/casdoor-go-sdk/casdoorsdk"
 	"github.com/labstack/echo/v4"
 	"github.com/skyapps-id/casdoor-test/config"
+	"github.com/skyapps-id/casdoor-test/problem"
+	"github.com/skyapps-id/casdoor-test/rolegrant"
+	"github.com/skyapps-id/casdoor-test/validation"
 )
 
+// roleSortFields maps ?sort= values to Casdoor columns
+var roleSortFields = map[string]string{
+	"name":         "name",
+	"display_name": "display_name",
+	"created_time": "created_time",
+}
+
+// ListRoles returns a page of the organization's roles.
+// Filters: search (name, display name), status (enabled|disabled).
 func ListRoles(c echo.Context) error {
-	roles, err := config.CasdoorClient.GetRoles()
+	pg, err := parsePage(c)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": "Failed to get roles",
+		return problem.BadRequest(err.Error())
+	}
+	queryMap := map[string]string{}
+	if err := parseSort(c, roleSortFields, queryMap); err != nil {
+		return problem.BadRequest(err.Error())
+	}
+
+	filters := []func(*casdoorsdk.Role) bool{}
+	if search := strings.ToLower(c.QueryParam("search")); search != "" {
+		filters = append(filters, func(r *casdoorsdk.Role) bool {
+			return strings.Contains(strings.ToLower(r.Name), search) ||
+				strings.Contains(strings.ToLower(r.DisplayName), search)
 		})
 	}
+	switch status := c.QueryParam("status"); status {
+	case "":
+	case "enabled", "disabled":
+		enabled := status == "enabled"
+		filters = append(filters, func(r *casdoorsdk.Role) bool { return r.IsEnabled == enabled })
+	default:
+		return problem.BadRequest("status must be enabled or disabled")
+	}
 
-	skyappsRoles := []interface{}{}
-	for _, role := range roles {
-		if role.Owner == "skyapps" {
-			skyappsRoles = append(skyappsRoles, map[string]interface{}{
-				"name":         role.Name,
-				"display_name": role.DisplayName,
-			})
+	fetch := func(p, size int) ([]*casdoorsdk.Role, int, error) {
+		q := map[string]string{}
+		for k, v := range queryMap {
+			q[k] = v
 		}
+		return config.CasdoorClient.GetPaginationRoles(p, size, q)
+	}
+
+	var roles []*casdoorsdk.Role
+	var total int
+	if len(filters) == 0 {
+		roles, total, err = fetch(pg.Number, pg.Size)
+	} else {
+		roles, total, err = scanPages(fetch, func(r *casdoorsdk.Role) bool {
+			for _, match := range filters {
+				if !match(r) {
+					return false
+				}
+			}
+			return true
+		}, pg)
+	}
+	if err != nil {
+		return problem.Casdoor(err, "Failed to get roles")
+	}
+
+	items := make([]interface{}, 0, len(roles))
+	for _, role := range roles {
+		items = append(items, map[string]interface{}{
+			"name":         role.Name,
+			"display_name": role.DisplayName,
+			"is_enabled":   role.IsEnabled,
+		})
+	}
+
+	setPaginationHeaders(c, pg, total)
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"roles":     items,
+		"total":     total,
+		"page":      pg.Number,
+		"page_size": pg.Size,
+	})
+}
+
+// GetRole returns a single role with its members and sub-roles
+func GetRole(c echo.Context) error {
+	role, err := config.CasdoorClient.GetRole(c.Param("role"))
+	if err != nil {
+		return problem.Casdoor(err, "Failed to get role")
+	}
+	if role == nil {
+		return problem.NotFound("Role not found")
 	}
 
+	c.Response().Header().Set("ETag", roleETag(role))
 	return c.JSON(http.StatusOK, map[string]interface{}{
-		"roles": skyappsRoles,
+		"name":         role.Name,
+		"organization": role.Owner,
+		"display_name": role.DisplayName,
+		"description":  role.Description,
+		"is_enabled":   role.IsEnabled,
+		"members":      nonNil(role.Users),
+		"groups":       nonNil(role.Groups),
+		"sub_roles":    nonNil(role.Roles),
+		"domains":      nonNil(role.Domains),
+		"created_time": role.CreatedTime,
 	})
 }
 
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
+
 func AddRole(c echo.Context) error {
 	var req struct {
-		Name        string `json:"name" validate:"required"`
-		DisplayName string `json:"display_name" validate:"required"`
+		Name        string `json:"name" validate:"required,identifier"`
+		DisplayName string `json:"display_name" validate:"required,max=100"`
 	}
 
 	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid request",
-		})
+		return problem.BadRequest("Invalid request")
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(err)
 	}
 
 	role := &casdoorsdk.Role{
@@ -50,10 +146,9 @@ func AddRole(c echo.Context) error {
 	}
 
 	affected, err := config.CasdoorClient.AddRole(role)
+	recordChange(c, "role.create", "role", req.Name, nil, role, mutationError(affected, err))
 	if err != nil || !affected {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": "Failed to create role",
-		})
+		return upstreamError(affected, err, "Failed to create role", problem.Conflict("Role already exists"))
 	}
 
 	return c.JSON(http.StatusCreated, map[string]string{
@@ -65,28 +160,38 @@ func UpdateRole(c echo.Context) error {
 	roleName := c.Param("role")
 
 	var req struct {
-		DisplayName string `json:"display_name"`
+		DisplayName string `json:"display_name" validate:"required,max=100"`
 	}
 
 	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid request",
-		})
+		return problem.BadRequest("Invalid request")
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(err)
 	}
 
-	role := &casdoorsdk.Role{
-		Owner:       "skyapps",
-		Name:        roleName,
-		DisplayName: req.DisplayName,
+	before, err := config.CasdoorClient.GetRole(roleName)
+	if err != nil {
+		return problem.Casdoor(err, "Failed to get role")
+	}
+	if before == nil {
+		return problem.NotFound("Role not found")
+	}
+	if failed, err := preconditionFailed(c, roleETag(before)); failed {
+		return err
 	}
 
-	affected, err := config.CasdoorClient.UpdateRole(role)
+	// Keep members, sub-roles and domains, only the display name changes
+	role := *before
+	role.DisplayName = req.DisplayName
+
+	affected, err := config.CasdoorClient.UpdateRole(&role)
+	recordChange(c, "role.update", "role", roleName, before, &role, mutationError(affected, err))
 	if err != nil || !affected {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": "Failed to update role",
-		})
+		return upstreamError(affected, err, "Failed to update role", problem.NotFound("Role not found"))
 	}
 
+	c.Response().Header().Set("ETag", roleETag(&role))
 	return c.JSON(http.StatusOK, map[string]string{
 		"message": "Role updated successfully",
 	})
@@ -95,16 +200,26 @@ func UpdateRole(c echo.Context) error {
 func DeleteRole(c echo.Context) error {
 	roleName := c.Param("role")
 
+	before, err := config.CasdoorClient.GetRole(roleName)
+	if err != nil {
+		return problem.Casdoor(err, "Failed to get role")
+	}
+	if before == nil {
+		return problem.NotFound("Role not found")
+	}
+	if failed, err := preconditionFailed(c, roleETag(before)); failed {
+		return err
+	}
+
 	role := &casdoorsdk.Role{
 		Owner: "skyapps",
 		Name:  roleName,
 	}
 
 	affected, err := config.CasdoorClient.DeleteRole(role)
+	recordChange(c, "role.delete", "role", roleName, before, nil, mutationError(affected, err))
 	if err != nil || !affected {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": "Failed to delete role",
-		})
+		return upstreamError(affected, err, "Failed to delete role", problem.NotFound("Role not found"))
 	}
 
 	return c.JSON(http.StatusOK, map[string]string{
@@ -112,75 +227,129 @@ func DeleteRole(c echo.Context) error {
 	})
 }
 
+// AssignRole grants a role, permanently or until expires_at. Temporary grants
+// are recorded in the store and removed by the grant reaper once expired.
 func AssignRole(c echo.Context) error {
 	username := c.Param("username")
+	ifMatch, err := ifMatchHeader(c)
+	if err != nil {
+		return err
+	}
 
 	var req struct {
-		Role string `json:"role" validate:"required"`
+		Role      string     `json:"role" validate:"required,identifier"`
+		ExpiresAt *time.Time `json:"expires_at"`
 	}
 
 	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid request",
-		})
+		return problem.BadRequest("Invalid request")
 	}
-
-	// Get user
-	user, err := config.CasdoorClient.GetUser(username)
-	if err != nil {
-		return c.JSON(http.StatusNotFound, map[string]string{
-			"error": "User not found",
-		})
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(err)
+	}
+	now := time.Now()
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(now) {
+		return validationFailed(validation.Errors{{Field: "expires_at", Rule: "future", Message: "must be in the future"}})
+	}
+	if req.ExpiresAt != nil && req.ExpiresAt.Sub(now) > config.API.RoleGrantMaxTTL {
+		return validationFailed(validation.Errors{{Field: "expires_at", Rule: "max", Message: "must be within " + config.API.RoleGrantMaxTTL.String()}})
 	}
 
-	// Add role to user
-	user.Roles = append(user.Roles, &casdoorsdk.Role{
-		Owner: "skyapps",
-		Name:  req.Role,
-	})
-
-	affected, err := config.CasdoorClient.UpdateUser(user)
-	if err != nil || !affected {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": "Failed to assign role",
-		})
+	// Add role on both sides, retried when the user changes underneath
+	user, grant, changed, err := assignRole(c.Request().Context(), changeContextOf(c),
+		username, req.Role, ifMatch, req.ExpiresAt)
+	if handled, resp := roleUpdateError(c, err); handled {
+		return resp
+	}
+	switch {
+	case errors.Is(err, errPermanentRole):
+		return problem.Conflict("Role is already assigned without expiry, remove it first")
+	case errors.Is(err, errGrantStore):
+		return problem.Internal("Failed to store role grant", err)
+	case err != nil:
+		return problem.Casdoor(err, "Failed to assign role")
 	}
 
-	return c.JSON(http.StatusOK, map[string]string{
-		"message": "Role assigned successfully",
-	})
+	message := "Role assigned successfully"
+	if !changed {
+		message = "Role already assigned"
+	}
+	resp := map[string]interface{}{
+		"message": message,
+		"changed": changed,
+	}
+	if grant != nil {
+		resp["expires_at"] = grant.ExpiresAt
+	}
+	c.Response().Header().Set("ETag", userETag(user))
+	return c.JSON(http.StatusOK, resp)
 }
 
 func RemoveRole(c echo.Context) error {
 	username := c.Param("username")
 	roleName := c.Param("role")
+	ifMatch, err := ifMatchHeader(c)
+	if err != nil {
+		return err
+	}
 
-	user, err := config.CasdoorClient.GetUser(username)
+	// Remove role on both sides, retried when the user changes underneath
+	before, user, changed, err := changeMembership(username, roleName, ifMatch, false)
+	if handled, resp := roleUpdateError(c, err); handled {
+		return resp
+	}
+	if changed || err != nil {
+		recordChange(c, "user.remove_role", "user", username, roleSnapshot(before), roleSnapshot(user), err)
+	}
 	if err != nil {
-		return c.JSON(http.StatusNotFound, map[string]string{
-			"error": "User not found",
-		})
+		return problem.Casdoor(err, "Failed to remove role")
+	}
+	if err := rolegrant.Delete(c.Request().Context(), config.Store, username, roleName); err != nil {
+		log.Printf("Failed to delete role grant %s/%s: %v", username, roleName, err)
 	}
 
-	// Remove role
-	newRoles := []*casdoorsdk.Role{}
-	for _, role := range user.Roles {
-		if role.Name != roleName {
-			newRoles = append(newRoles, role)
-		}
+	message := "Role removed successfully"
+	if !changed {
+		message = "Role was not assigned"
 	}
-	user.Roles = newRoles
+	c.Response().Header().Set("ETag", userETag(user))
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": message,
+		"changed": changed,
+	})
+}
 
-	affected, err := config.CasdoorClient.UpdateUser(user)
-	if err != nil || !affected {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": "Failed to remove role",
-		})
+// roleUpdateError answers the failures of role membership changes that happen
+// before anything was written (missing user or role, disabled role, lost update race)
+func roleUpdateError(c echo.Context, err error) (bool, error) {
+	switch {
+	case !rejectedBeforeWrite(err):
+		return false, nil
+	case errors.Is(err, errUserNotFound):
+		return true, problem.NotFound("User not found")
+	case errors.Is(err, errRoleNotFound):
+		return true, problem.NotFound("Role not found")
+	case errors.Is(err, errRoleDisabled):
+		return true, problem.Conflict("Role is disabled")
+	case errors.Is(err, errConcurrentUpdate):
+		if c.Request().Header.Get("If-Match") != "" {
+			return true, problem.New(http.StatusPreconditionFailed, problem.CodePreconditionFailed, "User was modified, reload it and retry")
+		}
+		return true, problem.Conflict("User is being modified concurrently, retry later")
 	}
+	return false, nil
+}
 
-	return c.JSON(http.StatusOK, map[string]string{
-		"message": "Role removed successfully",
-	})
+// roleSnapshot is the part of a user touched by role assignment, used for audit diffs
+func roleSnapshot(user *casdoorsdk.User) map[string]interface{} {
+	if user == nil {
+		return nil
+	}
+	roles := []string{}
+	for _, role := range user.Roles {
+		roles = append(roles, role.Name)
+	}
+	return map[string]interface{}{"roles": roles}
 }
 
 func SyncRBAC(c echo.Context) error {
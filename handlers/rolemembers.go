@@ -0,0 +1,314 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/casdoor/casdoor-go-sdk/casdoorsdk"
+	"github.com/labstack/echo/v4"
+	"github.com/skyapps-id/casdoor-test/config"
+	"github.com/skyapps-id/casdoor-test/problem"
+	"github.com/skyapps-id/casdoor-test/rolegrant"
+	"github.com/skyapps-id/casdoor-test/validation"
+)
+
+// Outcomes of a bulk role change per user
+const (
+	memberAdded      = "added"
+	memberRemoved    = "removed"
+	memberUnchanged  = "unchanged"
+	memberFailed     = "failed"
+	memberSkipped    = "skipped"
+	memberRolledBack = "rolled_back"
+)
+
+// memberResult reports what happened to one user of a bulk role change
+type memberResult struct {
+	Username string `json:"username"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+type roleMembersRequest struct {
+	Usernames []string `json:"usernames" validate:"required,min=1,max=500,unique,dive,username"`
+	// partial (default) keeps what succeeded, atomic undoes it on the first failure
+	Mode string `json:"mode" validate:"omitempty,oneof=partial atomic"`
+}
+
+// AddRoleMembers assigns the role to a list of users
+func AddRoleMembers(c echo.Context) error {
+	return changeRoleMembers(c, true)
+}
+
+// RemoveRoleMembers removes the role from a list of users
+func RemoveRoleMembers(c echo.Context) error {
+	return changeRoleMembers(c, false)
+}
+
+// changeRoleMembers applies one role change user by user. In partial mode
+// every user gets a result and failures answer 207; in atomic mode the first
+// failure reverts the users already changed and nothing is kept.
+func changeRoleMembers(c echo.Context, add bool) error {
+	roleName := c.Param("role")
+
+	var req roleMembersRequest
+	if err := c.Bind(&req); err != nil {
+		return problem.BadRequest("Invalid request")
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(err)
+	}
+
+	role, err := config.CasdoorClient.GetRole(roleName)
+	if err != nil {
+		return problem.Casdoor(err, "Failed to get role")
+	}
+	if role == nil {
+		return problem.NotFound("Role not found")
+	}
+	if add && !role.IsEnabled {
+		return problem.Conflict("Role is disabled")
+	}
+
+	apply := func(username string, add bool) (bool, error) {
+		before, after, changed, err := changeMembership(username, roleName, "", add)
+		if changed || err != nil {
+			action := "user.assign_role"
+			if !add {
+				action = "user.remove_role"
+			}
+			recordChange(c, action, "user", username, roleSnapshot(before), roleSnapshot(after), err)
+		}
+		return changed, err
+	}
+
+	done := memberAdded
+	if !add {
+		done = memberRemoved
+	}
+	results := make([]memberResult, len(req.Usernames))
+	changed, failed := 0, 0
+	for i, username := range req.Usernames {
+		results[i] = memberResult{Username: username, Status: memberUnchanged}
+		ok, err := apply(username, add)
+		if err == nil {
+			if ok {
+				results[i].Status = done
+				changed++
+			}
+			continue
+		}
+
+		failed++
+		results[i].Status = memberFailed
+		results[i].Error = memberError(username, roleName, err)
+		if req.Mode != "atomic" {
+			continue
+		}
+
+		// Undo in reverse order, the rest of the list is not attempted
+		for j := i - 1; j >= 0; j-- {
+			if results[j].Status != done {
+				continue
+			}
+			if _, err := apply(results[j].Username, !add); err != nil {
+				results[j].Error = "rollback failed: " + memberError(results[j].Username, roleName, err)
+				continue
+			}
+			results[j].Status = memberRolledBack
+		}
+		for j := i + 1; j < len(results); j++ {
+			results[j] = memberResult{Username: req.Usernames[j], Status: memberSkipped}
+		}
+		return problem.Conflict(fmt.Sprintf("Changing %s failed, no changes were kept", username)).
+			With("role", roleName).
+			With("results", results)
+	}
+
+	// Temporary grants of removed users go only once the change is kept, an
+	// atomic rollback re-adds the role with its expiry still in place
+	if !add {
+		for _, result := range results {
+			if result.Status == memberFailed {
+				continue
+			}
+			if err := rolegrant.Delete(c.Request().Context(), config.Store, result.Username, roleName); err != nil {
+				log.Printf("Failed to delete role grant %s/%s: %v", result.Username, roleName, err)
+			}
+		}
+	}
+
+	status := http.StatusOK
+	if failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	return c.JSON(status, map[string]interface{}{
+		"role":    roleName,
+		"changed": changed,
+		"failed":  failed,
+		"results": results,
+	})
+}
+
+// SetUserRoles replaces the user's roles with exactly the given set, written
+// in a single user update. If the roles' member lists cannot be brought in
+// line the user's previous roles are written back, so nothing is kept.
+func SetUserRoles(c echo.Context) error {
+	username := c.Param("username")
+	ifMatch, err := ifMatchHeader(c)
+	if err != nil {
+		return err
+	}
+
+	var req struct {
+		Roles []string `json:"roles" validate:"required,max=50,unique,dive,identifier"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return problem.BadRequest("Invalid request")
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(err)
+	}
+
+	roles, err := config.CasdoorClient.GetRoles()
+	if err != nil {
+		return problem.Casdoor(err, "Failed to get roles")
+	}
+	known := map[string]*casdoorsdk.Role{}
+	for _, role := range roles {
+		known[role.Name] = role
+	}
+	var invalid validation.Errors
+	for i, name := range req.Roles {
+		field := fmt.Sprintf("roles[%d]", i)
+		switch role := known[name]; {
+		case role == nil:
+			invalid = append(invalid, validation.FieldError{Field: field, Rule: "exists", Message: "role " + name + " does not exist"})
+		case !role.IsEnabled:
+			invalid = append(invalid, validation.FieldError{Field: field, Rule: "enabled", Message: "role " + name + " is disabled"})
+		}
+	}
+	if len(invalid) > 0 {
+		return validationFailed(invalid)
+	}
+
+	before, user, changed, err := updateUserRoles(username, ifMatch, func(user *casdoorsdk.User) bool {
+		current := []string{}
+		for _, role := range user.Roles {
+			current = append(current, role.Name)
+		}
+		if sameSet(current, req.Roles) {
+			return false
+		}
+		user.Roles = []*casdoorsdk.Role{}
+		for _, name := range req.Roles {
+			user.Roles = append(user.Roles, &casdoorsdk.Role{Owner: "skyapps", Name: name})
+		}
+		return true
+	})
+	if handled, resp := roleUpdateError(c, err); handled {
+		return resp
+	}
+	if changed || err != nil {
+		recordChange(c, "user.set_roles", "user", username, roleSnapshot(before), roleSnapshot(user), err)
+	}
+	if err != nil {
+		return problem.Casdoor(err, "Failed to set roles")
+	}
+
+	// Bring the Users lists of the added and removed roles in line. A failure
+	// undoes the roles already written and restores the user's previous roles.
+	member := user.Owner + "/" + username
+	updated := []*casdoorsdk.Role{}
+	for _, role := range roles {
+		wanted := slices.Contains(req.Roles, role.Name)
+		if wanted == slices.Contains(role.Users, member) {
+			continue
+		}
+		if _, err := updateRoleUsers(role.Name, member, wanted); err != nil {
+			if undoErr := undoSetUserRoles(username, member, before, updated, req.Roles); undoErr != nil {
+				err = errors.Join(err, undoErr)
+				recordChange(c, "user.set_roles", "user", username, roleSnapshot(user), nil, err)
+				return problem.Casdoor(err, "Updating the members of "+role.Name+" failed and the roles could not be restored")
+			}
+			recordChange(c, "user.set_roles", "user", username, roleSnapshot(user), roleSnapshot(before), err)
+			return problem.Casdoor(err, "Updating the members of "+role.Name+" failed, no changes were kept")
+		}
+		updated = append(updated, role)
+	}
+
+	// Grants of removed roles must not take away a later permanent assignment
+	grants, err := rolegrant.ForUser(c.Request().Context(), config.Store, username)
+	if err != nil {
+		log.Printf("Failed to load role grants of %s: %v", username, err)
+	}
+	for name := range grants {
+		if slices.Contains(req.Roles, name) {
+			continue
+		}
+		if err := rolegrant.Delete(c.Request().Context(), config.Store, username, name); err != nil {
+			log.Printf("Failed to delete role grant %s/%s: %v", username, name, err)
+		}
+	}
+
+	c.Response().Header().Set("ETag", userETag(user))
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "Roles updated successfully",
+		"changed": changed,
+		"roles":   req.Roles,
+	})
+}
+
+// memberError is the per-user error of a bulk change. Casdoor's own message
+// only goes to the log, it can leak upstream internals.
+func memberError(username, roleName string, err error) string {
+	switch {
+	case errors.Is(err, errUserNotFound):
+		return "user not found"
+	case errors.Is(err, errRoleNotFound):
+		return "role not found"
+	case errors.Is(err, errConcurrentUpdate):
+		return "user is being modified concurrently"
+	case errors.Is(err, errRoleDisabled):
+		return "role is disabled"
+	}
+	log.Printf("Changing role %s of %s failed: %v", roleName, username, err)
+	return "update failed"
+}
+
+// undoSetUserRoles reverts the role-side updates already made by SetUserRoles
+// and writes the user's previous roles back
+func undoSetUserRoles(username, member string, before *casdoorsdk.User, updated []*casdoorsdk.Role, wanted []string) error {
+	var errs []error
+	for i := len(updated) - 1; i >= 0; i-- {
+		role := updated[i]
+		if _, err := updateRoleUsers(role.Name, member, !slices.Contains(wanted, role.Name)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if _, _, _, err := updateUserRoles(username, "", func(user *casdoorsdk.User) bool {
+		user.Roles = before.Roles
+		return true
+	}); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// sameSet compares a and b as sets, ignoring order and duplicates
+func sameSet(a, b []string) bool {
+	for _, v := range a {
+		if !slices.Contains(b, v) {
+			return false
+		}
+	}
+	for _, v := range b {
+		if !slices.Contains(a, v) {
+			return false
+		}
+	}
+	return true
+}
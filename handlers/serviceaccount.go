@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/casdoor/casdoor-go-sdk/casdoorsdk"
+	"github.com/labstack/echo/v4"
+	"github.com/skyapps-id/casdoor-test/config"
+	"github.com/skyapps-id/casdoor-test/problem"
+	"github.com/skyapps-id/casdoor-test/serviceaccount"
+)
+
+func ListServiceAccounts(c echo.Context) error {
+	accounts, err := serviceaccount.List(c.Request().Context(), config.Store)
+	if err != nil {
+		return problem.Internal("Failed to get service accounts", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"service_accounts": accounts,
+		"total":            len(accounts),
+	})
+}
+
+func AddServiceAccount(c echo.Context) error {
+	var req struct {
+		Name     string   `json:"name" validate:"required,identifier"`
+		Roles    []string `json:"roles" validate:"required,min=1,dive,required"`
+		ClientID string   `json:"client_id"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return problem.BadRequest("Invalid request")
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(err)
+	}
+
+	for _, name := range req.Roles {
+		role, err := config.CasdoorClient.GetRole(name)
+		if err != nil || role == nil {
+			return problem.BadRequest("Role not found: " + name)
+		}
+	}
+
+	account := &serviceaccount.Account{
+		Name:     req.Name,
+		Roles:    req.Roles,
+		ClientID: req.ClientID,
+	}
+	if actor, ok := c.Get("casdoorUser").(*casdoorsdk.User); ok && actor != nil {
+		account.CreatedBy = actor.Name
+	}
+
+	err := serviceaccount.Create(c.Request().Context(), config.Store, account)
+	recordChange(c, "service_account.create", "service_account", req.Name, nil, account, err)
+	if errors.Is(err, serviceaccount.ErrClientIDInUse) {
+		return problem.Conflict("client_id is already mapped to another service account")
+	}
+	if errors.Is(err, serviceaccount.ErrExists) {
+		return problem.Conflict("Service account already exists")
+	}
+	if err != nil {
+		return problem.Internal("Failed to create service account", err)
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"message":         "Service account created successfully",
+		"service_account": account,
+	})
+}
+
+func DeleteServiceAccount(c echo.Context) error {
+	name := c.Param("name")
+	ctx := c.Request().Context()
+
+	before, _ := serviceaccount.Get(ctx, config.Store, name)
+	err := serviceaccount.Delete(ctx, config.Store, name)
+	recordChange(c, "service_account.delete", "service_account", name, before, nil, err)
+	if errors.Is(err, serviceaccount.ErrNotFound) {
+		return problem.NotFound("Service account not found")
+	}
+	if err != nil {
+		return problem.Internal("Failed to delete service account", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Service account deleted successfully",
+	})
+}
+
+func ListAPIKeys(c echo.Context) error {
+	name := c.Param("name")
+	ctx := c.Request().Context()
+
+	if _, err := serviceaccount.Get(ctx, config.Store, name); err != nil {
+		return serviceAccountError(c, err)
+	}
+
+	keys, err := serviceaccount.ListKeys(ctx, config.Store, name)
+	if err != nil {
+		return problem.Internal("Failed to get API keys", err)
+	}
+
+	views := make([]serviceaccount.KeyView, 0, len(keys))
+	for _, key := range keys {
+		views = append(views, key.View())
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"keys":  views,
+		"total": len(views),
+	})
+}
+
+func CreateAPIKey(c echo.Context) error {
+	name := c.Param("name")
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return problem.BadRequest("Invalid request")
+	}
+
+	key, secret, err := serviceaccount.CreateKey(c.Request().Context(), config.Store, name, req.Name)
+	if err != nil {
+		return serviceAccountError(c, err)
+	}
+	recordChange(c, "api_key.create", "service_account", name, nil, key.View(), nil)
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"message": "API key created, store it now, it will not be shown again",
+		"key":     key.View(),
+		"api_key": secret,
+	})
+}
+
+func RotateAPIKey(c echo.Context) error {
+	name := c.Param("name")
+	id := c.Param("id")
+
+	key, secret, err := serviceaccount.RotateKey(c.Request().Context(), config.Store, name, id)
+	if err != nil {
+		return serviceAccountError(c, err)
+	}
+	recordChange(c, "api_key.rotate", "service_account", name, nil, key.View(), nil)
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "API key rotated, store it now, it will not be shown again",
+		"key":     key.View(),
+		"api_key": secret,
+	})
+}
+
+func RevokeAPIKey(c echo.Context) error {
+	name := c.Param("name")
+	id := c.Param("id")
+	ctx := c.Request().Context()
+
+	before, _ := serviceaccount.GetKey(ctx, config.Store, name, id)
+	err := serviceaccount.RevokeKey(ctx, config.Store, name, id)
+	if before != nil {
+		recordChange(c, "api_key.revoke", "service_account", name, before.View(), nil, err)
+	}
+	if err != nil {
+		return serviceAccountError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "API key revoked successfully",
+	})
+}
+
+func serviceAccountError(c echo.Context, err error) error {
+	if errors.Is(err, serviceaccount.ErrNotFound) {
+		return problem.NotFound("Service account or API key not found")
+	}
+	return problem.Internal("Failed to manage API key", err)
+}
@@ -0,0 +1,310 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/skyapps-id/casdoor-test/config"
+	"github.com/skyapps-id/casdoor-test/internal/secrets"
+	"github.com/skyapps-id/casdoor-test/problem"
+	"github.com/skyapps-id/casdoor-test/session"
+	"github.com/skyapps-id/casdoor-test/store"
+	"golang.org/x/oauth2"
+)
+
+const (
+	refreshCookieName = "refresh_token"
+	idTokenCookieName = "id_token"
+
+	refreshKeyPrefix       = "refresh:"
+	refreshUsedKeyPrefix   = "refresh-used:"
+	refreshFamilyKeyPrefix = "refresh-family-revoked:"
+)
+
+var (
+	errRefreshUnknown = errors.New("unknown refresh token")
+	errRefreshRevoked = errors.New("refresh token family revoked")
+	errRefreshReused  = errors.New("refresh token reuse detected, all sessions of this login were revoked")
+)
+
+// refreshRecord tracks a refresh token we handed out. Every token obtained by
+// rotating the same login shares one family, so reuse of an old token can
+// revoke the whole chain.
+type refreshRecord struct {
+	Family string `json:"family"`
+	User   string `json:"user"`
+}
+
+// RefreshToken exchanges a refresh token (JSON body or refresh_token cookie)
+// for a new token set and rotates the refresh token.
+func RefreshToken(c echo.Context) error {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return problem.BadRequest("Invalid request")
+	}
+	if req.RefreshToken == "" {
+		if cookie, err := c.Cookie(refreshCookieName); err == nil {
+			req.RefreshToken = cookie.Value
+		}
+	}
+	if req.RefreshToken == "" {
+		return problem.BadRequest("Missing refresh token")
+	}
+
+	ctx := c.Request().Context()
+	record, err := useRefreshToken(ctx, req.RefreshToken)
+	if err != nil {
+		if errors.Is(err, errRefreshReused) {
+			log.Printf("⚠️  Refresh token reuse detected for user %s, family %s revoked", record.User, record.Family)
+		}
+		return problem.Unauthorized(err.Error())
+	}
+
+	token, err := config.CasdoorClient.RefreshOAuthToken(req.RefreshToken)
+	if err != nil {
+		// Not rotated, a retry after a Casdoor timeout is not a reuse
+		releaseRefreshToken(ctx, req.RefreshToken)
+		return problem.Unauthorized("Failed to refresh token")
+	}
+
+	if err := trackRefreshToken(ctx, token, record.Family); err != nil {
+		return problem.Internal("Failed to store refresh token", err)
+	}
+
+	return respondWithTokens(c, token, "")
+}
+
+// trackRefreshToken registers the refresh token of a new token set, family "" starts a new one
+func trackRefreshToken(ctx context.Context, token *oauth2.Token, family string) error {
+	if token.RefreshToken == "" {
+		return nil
+	}
+	if family == "" {
+		family = secrets.Token()
+	}
+
+	record := refreshRecord{Family: family}
+	if claims, err := config.CasdoorClient.ParseJwtToken(token.AccessToken); err == nil {
+		record.User = claims.User.Name
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return config.Store.Set(ctx, refreshKeyPrefix+secrets.Hash(token.RefreshToken), data, config.Auth.RefreshTokenTTL)
+}
+
+// useRefreshToken marks a refresh token as used exactly once. A second use
+// means the token leaked, so the whole family is revoked. The mark is taken
+// before the exchange so concurrent uses cannot both pass, and released with
+// releaseRefreshToken when Casdoor does not rotate the token.
+func useRefreshToken(ctx context.Context, refreshToken string) (*refreshRecord, error) {
+	hash := secrets.Hash(refreshToken)
+
+	data, err := config.Store.Get(ctx, refreshKeyPrefix+hash)
+	if errors.Is(err, store.ErrNotFound) {
+		return &refreshRecord{}, errRefreshUnknown
+	}
+	if err != nil {
+		return &refreshRecord{}, err
+	}
+
+	var record refreshRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return &refreshRecord{}, err
+	}
+
+	if _, err := config.Store.Get(ctx, refreshFamilyKeyPrefix+record.Family); err == nil {
+		return &record, errRefreshRevoked
+	}
+
+	first, err := config.Store.SetNX(ctx, refreshUsedKeyPrefix+hash, []byte("1"), config.Auth.RefreshTokenTTL)
+	if err != nil {
+		return &record, err
+	}
+	if !first {
+		if err := config.Store.Set(ctx, refreshFamilyKeyPrefix+record.Family, []byte("1"), config.Auth.RefreshTokenTTL); err != nil {
+			return &record, err
+		}
+		return &record, errRefreshReused
+	}
+	return &record, nil
+}
+
+// releaseRefreshToken takes back the used mark of useRefreshToken when the
+// token could not be exchanged
+func releaseRefreshToken(ctx context.Context, refreshToken string) {
+	if err := config.Store.Delete(ctx, refreshUsedKeyPrefix+secrets.Hash(refreshToken)); err != nil {
+		log.Printf("Failed to release refresh token: %v", err)
+	}
+}
+
+// revokeRefreshFamily revokes every refresh token rotated from the same login
+func revokeRefreshFamily(ctx context.Context, refreshToken string) error {
+	data, err := config.Store.Get(ctx, refreshKeyPrefix+secrets.Hash(refreshToken))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var record refreshRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return err
+	}
+	return config.Store.Set(ctx, refreshFamilyKeyPrefix+record.Family, []byte("1"), config.Auth.RefreshTokenTTL)
+}
+
+// clearTokenCookies expires the cookies set by respondWithTokens
+func clearTokenCookies(c echo.Context) {
+	c.SetCookie(tokenCookie(refreshCookieName, "", "/auth", -time.Second))
+	c.SetCookie(tokenCookie(idTokenCookieName, "", "/", -time.Second))
+}
+
+// respondWithTokens returns a token set in the configured form: everything in
+// the JSON body, refresh/ID token in HttpOnly cookies and only the access
+// token in the body, or (session mode) no token at all but an encrypted
+// session cookie. A non-empty returnTo redirects with the access token in the
+// URL fragment instead.
+func respondWithTokens(c echo.Context, token *oauth2.Token, returnTo string) error {
+	idToken, _ := token.Extra("id_token").(string)
+
+	if config.Auth.TokenDelivery == "session" {
+		if err := startSession(c, token, idToken); err != nil {
+			return problem.Internal("Failed to start session", err)
+		}
+		if returnTo != "" {
+			return c.Redirect(http.StatusFound, returnTo)
+		}
+		return c.JSON(http.StatusOK, map[string]string{
+			"message": "Logged in successfully",
+		})
+	}
+
+	body := map[string]interface{}{
+		"token":      token.AccessToken,
+		"expires_in": token.Expiry,
+	}
+
+	if config.Auth.TokenDelivery == "cookie" {
+		if token.RefreshToken != "" {
+			c.SetCookie(tokenCookie(refreshCookieName, token.RefreshToken, "/auth", config.Auth.RefreshTokenTTL))
+		}
+		if idToken != "" {
+			c.SetCookie(tokenCookie(idTokenCookieName, idToken, "/", time.Until(token.Expiry)))
+		}
+	} else {
+		body["refresh_token"] = token.RefreshToken
+		body["id_token"] = idToken
+	}
+
+	if returnTo != "" {
+		// Fragment is never sent to servers, so tokens do not end up in access logs
+		fragment := url.Values{}
+		fragment.Set("access_token", token.AccessToken)
+		fragment.Set("expires_in", fmt.Sprint(int(time.Until(token.Expiry).Seconds())))
+		if config.Auth.TokenDelivery == "body" {
+			fragment.Set("refresh_token", token.RefreshToken)
+			fragment.Set("id_token", idToken)
+		}
+		return c.Redirect(http.StatusFound, returnTo+"#"+fragment.Encode())
+	}
+
+	return c.JSON(http.StatusOK, body)
+}
+
+// startSession keeps the tokens server-side and hands the browser an
+// encrypted session cookie plus a CSRF token readable by JavaScript
+func startSession(c echo.Context, token *oauth2.Token, idToken string) error {
+	sess := &session.Session{
+		ID:           secrets.Token(),
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		IDToken:      idToken,
+		Expiry:       token.Expiry,
+		CSRFToken:    secrets.Token(),
+	}
+	if claims, err := config.CasdoorClient.ParseJwtToken(token.AccessToken); err == nil {
+		sess.User = claims.User.Name
+	}
+
+	if err := session.Save(c.Request().Context(), config.Store, sess, config.Auth.SessionTTL); err != nil {
+		return err
+	}
+
+	value, err := session.EncodeCookie(config.Auth.CookieSecret, sess.ID, config.Auth.SessionTTL)
+	if err != nil {
+		return err
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     session.CookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(config.Auth.SessionTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   config.Auth.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	c.SetCookie(&http.Cookie{
+		Name:     session.CSRFCookieName,
+		Value:    sess.CSRFToken,
+		Path:     "/",
+		MaxAge:   int(config.Auth.SessionTTL.Seconds()),
+		Secure:   config.Auth.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// endSession deletes the server-side session behind the cookie and returns it
+// so its tokens can be revoked. Returns nil when there is no valid session.
+func endSession(c echo.Context) *session.Session {
+	defer func() {
+		for _, name := range []string{session.CookieName, session.CSRFCookieName} {
+			c.SetCookie(&http.Cookie{Name: name, Value: "", Path: "/", MaxAge: -1, Secure: config.Auth.CookieSecure})
+		}
+	}()
+
+	cookie, err := c.Cookie(session.CookieName)
+	if err != nil {
+		return nil
+	}
+	id, err := session.DecodeCookie(config.Auth.CookieSecret, cookie.Value)
+	if err != nil {
+		return nil
+	}
+
+	ctx := c.Request().Context()
+	sess, err := session.Load(ctx, config.Store, id)
+	if err != nil {
+		return nil
+	}
+	if err := session.Delete(ctx, config.Store, id); err != nil {
+		log.Printf("Failed to delete session: %v", err)
+	}
+	return sess
+}
+
+func tokenCookie(name, value, path string, ttl time.Duration) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		MaxAge:   int(ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   config.Auth.CookieSecure,
+		SameSite: http.SameSiteStrictMode,
+	}
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/skyapps-id/casdoor-test/config"
+	"github.com/skyapps-id/casdoor-test/internal/secrets"
+	"github.com/skyapps-id/casdoor-test/store"
+)
+
+// saveRefreshToken registers a refresh token like trackRefreshToken, without
+// asking Casdoor for the user
+func saveRefreshToken(t *testing.T, token, family string) {
+	t.Helper()
+	data, _ := json.Marshal(refreshRecord{Family: family, User: "alice"})
+	if err := config.Store.Set(context.Background(), refreshKeyPrefix+secrets.Hash(token), data, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUseRefreshToken(t *testing.T) {
+	config.Store = store.NewMemoryStore()
+	config.Auth.RefreshTokenTTL = time.Hour
+	ctx := context.Background()
+
+	saveRefreshToken(t, "first", "family-a")
+	saveRefreshToken(t, "second", "family-a")
+	saveRefreshToken(t, "other", "family-b")
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"unknown token", "missing", errRefreshUnknown},
+		{"first use rotates", "first", nil},
+		{"reuse revokes the family", "first", errRefreshReused},
+		{"rest of the family is revoked", "second", errRefreshRevoked},
+		{"other families still work", "other", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record, err := useRefreshToken(ctx, tt.token)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("useRefreshToken(%q) error = %v, want %v", tt.token, err, tt.want)
+			}
+			if tt.want != errRefreshUnknown && record.User != "alice" {
+				t.Errorf("record.User = %q, want alice", record.User)
+			}
+		})
+	}
+}
+
+func TestReleaseRefreshToken(t *testing.T) {
+	config.Store = store.NewMemoryStore()
+	config.Auth.RefreshTokenTTL = time.Hour
+	ctx := context.Background()
+
+	saveRefreshToken(t, "token", "family")
+	if _, err := useRefreshToken(ctx, "token"); err != nil {
+		t.Fatal(err)
+	}
+	// A failed upstream refresh gives the token back
+	releaseRefreshToken(ctx, "token")
+	if _, err := useRefreshToken(ctx, "token"); err != nil {
+		t.Fatalf("useRefreshToken after release: %v", err)
+	}
+}
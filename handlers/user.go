@@ -1,12 +1,15 @@
 package handlers
 
 import (
-	"fmt"
+	"encoding/json"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/casdoor/casdoor-go-sdk/casdoorsdk"
 	"github.com/labstack/echo/v4"
 	"github.com/skyapps-id/casdoor-test/config"
+	"github.com/skyapps-id/casdoor-test/problem"
 )
 
 func HealthCheck(c echo.Context) error {
@@ -15,102 +18,178 @@ func HealthCheck(c echo.Context) error {
 	})
 }
 
-func GetLoginURL(c echo.Context) error {
-	url := config.CasdoorClient.GetSigninUrl("http://localhost:9000/callback")
-	return c.JSON(http.StatusOK, map[string]string{
-		"url": url,
-	})
+// userSortFields maps ?sort= values to Casdoor columns
+var userSortFields = map[string]string{
+	"name":         "name",
+	"display_name": "display_name",
+	"email":        "email",
+	"created_time": "created_time",
 }
 
-func HandleCallback(c echo.Context) error {
-	code := c.QueryParam("code")
-	state := c.QueryParam("state")
-
-	token, err := config.CasdoorClient.GetOAuthToken(code, state)
+// ListUsers returns a page of the organization's users.
+// Filters: role, email_domain, created_from/created_to (RFC3339),
+// status (enabled|forbidden), search (name, display name, email).
+// Without filters the page maps directly onto Casdoor's paginated API.
+func ListUsers(c echo.Context) error {
+	pg, err := parsePage(c)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Failed to get token",
-		})
+		return problem.BadRequest(err.Error())
+	}
+	queryMap := map[string]string{}
+	if err := parseSort(c, userSortFields, queryMap); err != nil {
+		return problem.BadRequest(err.Error())
 	}
 
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"token":      token.AccessToken,
-		"expires_in": token.Expiry,
-	})
-}
+	filters := []func(*casdoorsdk.User) bool{}
 
-func GetCurrentUser(c echo.Context) error {
-	userID, ok := c.Get("user_id").(string)
-	if !ok || userID == "" {
-		return c.JSON(http.StatusUnauthorized, map[string]string{
-			"error": "User ID missing or invalid",
+	if domain := strings.ToLower(strings.TrimPrefix(c.QueryParam("email_domain"), "@")); domain != "" {
+		// Casdoor narrows with LIKE, the suffix check makes it exact
+		queryMap["field"] = "email"
+		queryMap["value"] = "@" + domain
+		filters = append(filters, func(u *casdoorsdk.User) bool {
+			return strings.HasSuffix(strings.ToLower(u.Email), "@"+domain)
 		})
 	}
-
-	userEmail, ok := c.Get("user_email").(string)
-	if !ok || userEmail == "" {
-		return c.JSON(http.StatusUnauthorized, map[string]string{
-			"error": "User email missing or invalid",
+	if search := strings.ToLower(c.QueryParam("search")); search != "" {
+		filters = append(filters, func(u *casdoorsdk.User) bool {
+			return strings.Contains(strings.ToLower(u.Name), search) ||
+				strings.Contains(strings.ToLower(u.DisplayName), search) ||
+				strings.Contains(strings.ToLower(u.Email), search)
 		})
 	}
+	switch status := c.QueryParam("status"); status {
+	case "":
+	case "enabled", "forbidden":
+		forbidden := status == "forbidden"
+		filters = append(filters, func(u *casdoorsdk.User) bool { return u.IsForbidden == forbidden })
+	default:
+		return problem.BadRequest("status must be enabled or forbidden")
+	}
+	for _, bound := range []struct {
+		param string
+		after bool
+	}{{"created_from", true}, {"created_to", false}} {
+		v := c.QueryParam(bound.param)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return problem.BadRequest(bound.param + " must be an RFC3339 timestamp")
+		}
+		after := bound.after
+		filters = append(filters, func(u *casdoorsdk.User) bool {
+			created, err := time.Parse(time.RFC3339, u.CreatedTime)
+			if err != nil {
+				return false
+			}
+			if after {
+				return !created.Before(t)
+			}
+			return !created.After(t)
+		})
+	}
+	if roleName := c.QueryParam("role"); roleName != "" {
+		role, err := config.CasdoorClient.GetRole(roleName)
+		if err != nil {
+			return problem.Casdoor(err, "Failed to get role")
+		}
+		members := map[string]bool{}
+		if role != nil {
+			for _, member := range role.Users {
+				members[member] = true
+			}
+		}
+		filters = append(filters, func(u *casdoorsdk.User) bool { return members[u.Owner+"/"+u.Name] })
+	}
 
-	// Get full user info dari Casdoor
-	user, err := config.CasdoorClient.GetUser(userID)
+	fetch := func(p, size int) ([]*casdoorsdk.User, int, error) {
+		q := map[string]string{}
+		for k, v := range queryMap {
+			q[k] = v
+		}
+		return config.CasdoorClient.GetPaginationUsers(p, size, q)
+	}
+
+	var users []*casdoorsdk.User
+	var total int
+	if len(filters) == 0 {
+		users, total, err = fetch(pg.Number, pg.Size)
+	} else {
+		users, total, err = scanPages(fetch, func(u *casdoorsdk.User) bool {
+			for _, match := range filters {
+				if !match(u) {
+					return false
+				}
+			}
+			return true
+		}, pg)
+	}
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": "Failed to get user info",
-		})
+		return problem.Casdoor(err, "Failed to get users")
 	}
 
-	fmt.Println(user, "_+_+_+_+")
+	items := make([]interface{}, 0, len(users))
+	for _, user := range users {
+		items = append(items, map[string]interface{}{
+			"username":     user.Name,
+			"email":        user.Email,
+			"display_name": user.DisplayName,
+			"roles":        user.Roles,
+			"is_forbidden": user.IsForbidden,
+			"created_time": user.CreatedTime,
+		})
+	}
 
+	setPaginationHeaders(c, pg, total)
 	return c.JSON(http.StatusOK, map[string]interface{}{
-		"user_id": userID,
-		"email":   userEmail,
-		// "display_name": user.DisplayName,
-		// "roles":        user.Roles,
+		"users":     items,
+		"total":     total,
+		"page":      pg.Number,
+		"page_size": pg.Size,
 	})
 }
 
-func ListUsers(c echo.Context) error {
-	users, err := config.CasdoorClient.GetUsers()
+// GetUser returns a single user with roles, groups and custom properties
+func GetUser(c echo.Context) error {
+	user, err := config.CasdoorClient.GetUser(c.Param("username"))
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": "Failed to get users",
-		})
+		return problem.Casdoor(err, "Failed to get user")
 	}
-
-	// Filter hanya user dari skyapps
-	skyappsUsers := []interface{}{}
-	for _, user := range users {
-		if user.Owner == "skyapps" {
-			skyappsUsers = append(skyappsUsers, map[string]interface{}{
-				"username":     user.Name,
-				"email":        user.Email,
-				"display_name": user.DisplayName,
-				"roles":        user.Roles,
-			})
-		}
+	if user == nil {
+		return problem.NotFound("User not found")
 	}
 
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"users": skyappsUsers,
-		"total": len(skyappsUsers),
-	})
+	detail := editableUser(user)
+	detail["username"] = user.Name
+	detail["organization"] = user.Owner
+	detail["type"] = user.Type
+	detail["email_verified"] = user.EmailVerified
+	detail["groups"] = user.Groups
+	detail["mfa"] = mfaStatus(user)
+	detail["created_time"] = user.CreatedTime
+	detail["updated_time"] = user.UpdatedTime
+	detail["last_signin_time"] = user.LastSigninTime
+
+	detail["roles"] = roleEntries(c.Request().Context(), user)
+
+	c.Response().Header().Set("ETag", userETag(user))
+	return c.JSON(http.StatusOK, detail)
 }
 
 func AddUser(c echo.Context) error {
 	var req struct {
-		Username    string `json:"username" validate:"required"`
-		DisplayName string `json:"display_name" validate:"required"`
+		Username    string `json:"username" validate:"required,username"`
+		DisplayName string `json:"display_name" validate:"required,max=100"`
 		Email       string `json:"email" validate:"required,email"`
-		Password    string `json:"password" validate:"required,min=8"`
+		Password    string `json:"password" validate:"required,password"`
 	}
 
 	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid request",
-		})
+		return problem.BadRequest("Invalid request")
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(err)
 	}
 
 	user := &casdoorsdk.User{
@@ -122,10 +201,9 @@ func AddUser(c echo.Context) error {
 	}
 
 	affected, err := config.CasdoorClient.AddUser(user)
+	recordChange(c, "user.create", "user", req.Username, nil, user, mutationError(affected, err))
 	if err != nil || !affected {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": "Failed to create user",
-		})
+		return upstreamError(affected, err, "Failed to create user", problem.Conflict("User already exists"))
 	}
 
 	return c.JSON(http.StatusCreated, map[string]interface{}{
@@ -137,52 +215,122 @@ func AddUser(c echo.Context) error {
 	})
 }
 
+// UpdateUser (PUT) replaces the editable fields of a user. Every editable
+// field must be present (null clears it), a left out is_forbidden must not
+// quietly unlock the user. Roles, password and other read-only fields are kept.
 func UpdateUser(c echo.Context) error {
+	var doc map[string]interface{}
+	if err := json.NewDecoder(c.Request().Body).Decode(&doc); err != nil || doc == nil {
+		return problem.BadRequest("Invalid request, expected a JSON object")
+	}
+	if missing := missingEditableFields(doc); len(missing) > 0 {
+		p := problem.New(http.StatusUnprocessableEntity, problem.CodeValidationFailed,
+			"A full replacement needs every editable field, use PATCH for partial updates")
+		p.Fields = missing
+		return p
+	}
+
+	return saveUserEdit(c, func(current map[string]interface{}) map[string]interface{} {
+		return doc
+	})
+}
+
+// PatchUser applies a JSON Merge Patch (RFC 7396) to the user's editable
+// fields: present keys are set, null clears, missing keys stay untouched
+func PatchUser(c echo.Context) error {
+	var patch map[string]interface{}
+	if err := json.NewDecoder(c.Request().Body).Decode(&patch); err != nil || patch == nil {
+		return problem.BadRequest("Invalid request, expected a JSON merge patch object")
+	}
+
+	return saveUserEdit(c, func(current map[string]interface{}) map[string]interface{} {
+		return mergePatch(current, patch).(map[string]interface{})
+	})
+}
+
+// saveUserEdit is the read-modify-write shared by PUT and PATCH: only the
+// changed columns are sent to Casdoor, and only if the caller's roles allow them
+func saveUserEdit(c echo.Context, edit func(current map[string]interface{}) map[string]interface{}) error {
 	username := c.Param("username")
 
-	var req struct {
-		DisplayName string `json:"display_name"`
-		Email       string `json:"email"`
+	before, err := config.CasdoorClient.GetUser(username)
+	if err != nil {
+		return problem.Casdoor(err, "Failed to get user")
+	}
+	if before == nil {
+		return problem.NotFound("User not found")
 	}
 
-	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid request",
+	if failed, err := preconditionFailed(c, userETag(before)); failed {
+		return err
+	}
+
+	updated, changed, err := applyEditable(before, edit(editableUser(before)))
+	if err != nil {
+		return problem.BadRequest(err.Error())
+	}
+	if len(changed) == 0 {
+		c.Response().Header().Set("ETag", userETag(before))
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"message": "User unchanged",
+			"user":    editableUser(before),
 		})
 	}
 
-	user := &casdoorsdk.User{
-		Owner:       "skyapps",
-		Name:        username,
-		DisplayName: req.DisplayName,
-		Email:       req.Email,
+	callerRoles := []string{}
+	if caller, ok := c.Get("casdoorUser").(*casdoorsdk.User); ok && caller != nil {
+		for _, role := range caller.Roles {
+			callerRoles = append(callerRoles, role.Name)
+		}
+	}
+	columns := []string{}
+	denied := []string{}
+	for _, f := range changed {
+		if !config.UserFieldAllowed(callerRoles, f.Name) {
+			denied = append(denied, f.Name)
+		}
+		columns = append(columns, f.Column)
+	}
+	if len(denied) > 0 {
+		return problem.Forbidden("Your role may not change: " + strings.Join(denied, ", "))
 	}
 
-	affected, err := config.CasdoorClient.UpdateUser(user)
+	affected, err := config.CasdoorClient.UpdateUserForColumns(updated, columns)
+	recordChange(c, "user.update", "user", username, before, updated, mutationError(affected, err))
 	if err != nil || !affected {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": "Failed to update user",
-		})
+		return upstreamError(affected, err, "Failed to update user", problem.NotFound("User not found"))
 	}
 
-	return c.JSON(http.StatusOK, map[string]string{
+	c.Response().Header().Set("ETag", userETag(updated))
+	return c.JSON(http.StatusOK, map[string]interface{}{
 		"message": "User updated successfully",
+		"user":    editableUser(updated),
 	})
 }
 
 func DeleteUser(c echo.Context) error {
 	username := c.Param("username")
 
+	before, err := config.CasdoorClient.GetUser(username)
+	if err != nil {
+		return problem.Casdoor(err, "Failed to get user")
+	}
+	if before == nil {
+		return problem.NotFound("User not found")
+	}
+	if failed, err := preconditionFailed(c, userETag(before)); failed {
+		return err
+	}
+
 	user := &casdoorsdk.User{
 		Owner: "skyapps",
 		Name:  username,
 	}
 
 	affected, err := config.CasdoorClient.DeleteUser(user)
+	recordChange(c, "user.delete", "user", username, before, nil, mutationError(affected, err))
 	if err != nil || !affected {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": "Failed to delete user",
-		})
+		return upstreamError(affected, err, "Failed to delete user", problem.NotFound("User not found"))
 	}
 
 	return c.JSON(http.StatusOK, map[string]string{
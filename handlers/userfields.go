@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+
+	"github.com/casdoor/casdoor-go-sdk/casdoorsdk"
+	"github.com/skyapps-id/casdoor-test/validation"
+)
+
+// userField is a user attribute editable through PUT/PATCH /api/users/:username.
+// Name is the API field, Column the Casdoor column written on update.
+type userField struct {
+	Name   string
+	Column string
+	get    func(u *casdoorsdk.User) interface{}
+	set    func(u *casdoorsdk.User, v interface{}) error
+}
+
+var userFields = []userField{
+	stringField("display_name", "display_name", func(u *casdoorsdk.User) *string { return &u.DisplayName }),
+	stringField("first_name", "first_name", func(u *casdoorsdk.User) *string { return &u.FirstName }),
+	stringField("last_name", "last_name", func(u *casdoorsdk.User) *string { return &u.LastName }),
+	stringField("email", "email", func(u *casdoorsdk.User) *string { return &u.Email }),
+	stringField("phone", "phone", func(u *casdoorsdk.User) *string { return &u.Phone }),
+	stringField("country_code", "country_code", func(u *casdoorsdk.User) *string { return &u.CountryCode }),
+	stringField("avatar", "avatar", func(u *casdoorsdk.User) *string { return &u.Avatar }),
+	stringField("title", "title", func(u *casdoorsdk.User) *string { return &u.Title }),
+	stringField("affiliation", "affiliation", func(u *casdoorsdk.User) *string { return &u.Affiliation }),
+	stringField("region", "region", func(u *casdoorsdk.User) *string { return &u.Region }),
+	stringField("location", "location", func(u *casdoorsdk.User) *string { return &u.Location }),
+	stringField("language", "language", func(u *casdoorsdk.User) *string { return &u.Language }),
+	stringField("bio", "bio", func(u *casdoorsdk.User) *string { return &u.Bio }),
+	stringField("homepage", "homepage", func(u *casdoorsdk.User) *string { return &u.Homepage }),
+	stringField("tag", "tag", func(u *casdoorsdk.User) *string { return &u.Tag }),
+	{
+		Name:   "is_forbidden",
+		Column: "is_forbidden",
+		get:    func(u *casdoorsdk.User) interface{} { return u.IsForbidden },
+		set: func(u *casdoorsdk.User, v interface{}) error {
+			if v == nil {
+				u.IsForbidden = false
+				return nil
+			}
+			b, ok := v.(bool)
+			if !ok {
+				return fmt.Errorf("is_forbidden must be a boolean")
+			}
+			u.IsForbidden = b
+			return nil
+		},
+	},
+	{
+		Name:   "properties",
+		Column: "properties",
+		get: func(u *casdoorsdk.User) interface{} {
+			if len(u.Properties) == 0 {
+				return map[string]string(nil)
+			}
+			return u.Properties
+		},
+		set: func(u *casdoorsdk.User, v interface{}) error {
+			u.Properties = map[string]string{}
+			if v == nil {
+				return nil
+			}
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("properties must be an object")
+			}
+			for k, pv := range m {
+				s, ok := pv.(string)
+				if !ok {
+					return fmt.Errorf("properties.%s must be a string", k)
+				}
+				u.Properties[k] = s
+			}
+			return nil
+		},
+	},
+}
+
+func stringField(name, column string, ptr func(u *casdoorsdk.User) *string) userField {
+	return userField{
+		Name:   name,
+		Column: column,
+		get:    func(u *casdoorsdk.User) interface{} { return *ptr(u) },
+		set: func(u *casdoorsdk.User, v interface{}) error {
+			if v == nil {
+				*ptr(u) = ""
+				return nil
+			}
+			s, ok := v.(string)
+			if !ok {
+				return fmt.Errorf("%s must be a string", name)
+			}
+			*ptr(u) = s
+			return nil
+		},
+	}
+}
+
+// editableUser is the JSON document PUT replaces and PATCH merges into
+func editableUser(u *casdoorsdk.User) map[string]interface{} {
+	doc := map[string]interface{}{}
+	for _, f := range userFields {
+		switch v := f.get(u).(type) {
+		case map[string]string:
+			props := map[string]interface{}{}
+			for k, pv := range v {
+				props[k] = pv
+			}
+			doc[f.Name] = props
+		default:
+			doc[f.Name] = v
+		}
+	}
+	return doc
+}
+
+// missingEditableFields lists the editable fields absent from a PUT document
+func missingEditableFields(doc map[string]interface{}) validation.Errors {
+	var missing validation.Errors
+	for _, f := range userFields {
+		if _, ok := doc[f.Name]; !ok {
+			missing = append(missing, validation.FieldError{Field: f.Name, Rule: "required", Message: "is required"})
+		}
+	}
+	return missing
+}
+
+// applyEditable returns a copy of current with every editable field taken
+// from doc (missing fields are cleared) and the fields that changed
+func applyEditable(current *casdoorsdk.User, doc map[string]interface{}) (*casdoorsdk.User, []userField, error) {
+	known := map[string]bool{}
+	for _, f := range userFields {
+		known[f.Name] = true
+	}
+	for name := range doc {
+		if !known[name] {
+			return nil, nil, fmt.Errorf("unknown or read-only field: %s", name)
+		}
+	}
+
+	updated := *current
+	changed := []userField{}
+	for _, f := range userFields {
+		if err := f.set(&updated, doc[f.Name]); err != nil {
+			return nil, nil, err
+		}
+		if !reflect.DeepEqual(f.get(current), f.get(&updated)) {
+			changed = append(changed, f)
+		}
+	}
+
+	if updated.DisplayName == "" {
+		return nil, nil, fmt.Errorf("display_name is required")
+	}
+	if addr, err := mail.ParseAddress(updated.Email); updated.Email != "" && (err != nil || addr.Address != updated.Email) {
+		return nil, nil, fmt.Errorf("email is not a valid address")
+	}
+	return &updated, changed, nil
+}
+
+// mergePatch applies an RFC 7396 JSON Merge Patch to target
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	result := map[string]interface{}{}
+	if t, ok := target.(map[string]interface{}); ok {
+		for k, v := range t {
+			result[k] = v
+		}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(result, k)
+		} else {
+			result[k] = mergePatch(result[k], v)
+		}
+	}
+	return result
+}
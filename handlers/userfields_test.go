@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/casdoor/casdoor-go-sdk/casdoorsdk"
+	"github.com/labstack/echo/v4"
+	"github.com/skyapps-id/casdoor-test/problem"
+)
+
+func TestMergePatch(t *testing.T) {
+	// Examples from RFC 7396, appendix A
+	tests := []struct {
+		target string
+		patch  string
+		want   string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tt := range tests {
+		var target, patch, want interface{}
+		_ = json.Unmarshal([]byte(tt.target), &target)
+		_ = json.Unmarshal([]byte(tt.patch), &patch)
+		_ = json.Unmarshal([]byte(tt.want), &want)
+		if got := mergePatch(target, patch); !reflect.DeepEqual(got, want) {
+			t.Errorf("mergePatch(%s, %s) = %v, want %s", tt.target, tt.patch, got, tt.want)
+		}
+	}
+}
+
+func TestApplyEditable(t *testing.T) {
+	current := &casdoorsdk.User{Name: "alice", DisplayName: "Alice", Email: "alice@example.com", Tag: "staff"}
+
+	tests := []struct {
+		name    string
+		patch   map[string]interface{}
+		changed []string
+		wantErr bool
+	}{
+		{"unchanged", map[string]interface{}{}, nil, false},
+		{"one field", map[string]interface{}{"title": "CTO"}, []string{"title"}, false},
+		{"cleared field", map[string]interface{}{"tag": nil}, []string{"tag"}, false},
+		{"unknown field", map[string]interface{}{"password": "x"}, nil, true},
+		{"wrong type", map[string]interface{}{"is_forbidden": "yes"}, nil, true},
+		{"display name required", map[string]interface{}{"display_name": nil}, nil, true},
+		{"invalid email", map[string]interface{}{"email": "not an address"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := mergePatch(editableUser(current), tt.patch).(map[string]interface{})
+			updated, changed, err := applyEditable(current, doc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("applyEditable error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			names := []string(nil)
+			for _, f := range changed {
+				names = append(names, f.Name)
+			}
+			if !reflect.DeepEqual(names, tt.changed) {
+				t.Errorf("changed = %v, want %v", names, tt.changed)
+			}
+			if updated.Name != current.Name {
+				t.Errorf("Name = %q, read-only fields must be kept", updated.Name)
+			}
+		})
+	}
+}
+
+func TestUpdateUserRequiresEveryField(t *testing.T) {
+	current := &casdoorsdk.User{Name: "alice", DisplayName: "Alice", Email: "alice@example.com", IsForbidden: true}
+	doc := editableUser(current)
+	delete(doc, "is_forbidden")
+	body, _ := json.Marshal(doc)
+
+	req := httptest.NewRequest(http.MethodPut, "/api/users/alice", bytes.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	c := echo.New().NewContext(req, httptest.NewRecorder())
+	c.SetParamNames("username")
+	c.SetParamValues("alice")
+
+	// Rejected before Casdoor is asked for the user
+	p := problem.From(UpdateUser(c))
+	if p.Status != http.StatusUnprocessableEntity {
+		t.Fatalf("UpdateUser without is_forbidden = %d, want 422", p.Status)
+	}
+	if len(p.Fields) != 1 || p.Fields[0].Field != "is_forbidden" {
+		t.Errorf("fields = %+v, want is_forbidden", p.Fields)
+	}
+
+	if missing := missingEditableFields(editableUser(current)); len(missing) != 0 {
+		t.Errorf("missingEditableFields of a full document = %+v", missing)
+	}
+}
@@ -0,0 +1,17 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/skyapps-id/casdoor-test/problem"
+	"github.com/skyapps-id/casdoor-test/validation"
+)
+
+// validationFailed turns a failed c.Validate into a problem with the field level errors
+func validationFailed(err error) error {
+	var fields validation.Errors
+	if errors.As(err, &fields) {
+		return problem.Validation(fields)
+	}
+	return problem.BadRequest(err.Error())
+}
@@ -0,0 +1,103 @@
+package impersonation
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/skyapps-id/casdoor-test/internal/secrets"
+	"github.com/skyapps-id/casdoor-test/store"
+)
+
+const (
+	Prefix = "imp_"
+
+	grantKeyPrefix = "impersonation:"
+)
+
+var ErrInvalidToken = errors.New("impersonation: invalid token")
+
+// Grant lets Actor act as Subject until ExpiresAt.
+// Only the SHA-256 hash of the secret is stored.
+type Grant struct {
+	ID        string    `json:"id"`
+	Subject   string    `json:"subject"`
+	Actor     string    `json:"actor"`
+	Reason    string    `json:"reason,omitempty"`
+	Hash      string    `json:"hash"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Create issues an impersonation token. The plaintext is only returned here.
+func Create(ctx context.Context, s store.Store, actor, subject, reason string, ttl time.Duration) (*Grant, string, error) {
+	id := secrets.HexID(8)
+	secret := secrets.Token()
+	now := time.Now().UTC()
+
+	grant := &Grant{
+		ID:        id,
+		Subject:   subject,
+		Actor:     actor,
+		Reason:    reason,
+		Hash:      secrets.Hash(secret),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	data, err := json.Marshal(grant)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := s.Set(ctx, grantKeyPrefix+id, data, ttl); err != nil {
+		return nil, "", err
+	}
+	return grant, Prefix + id + "_" + secret, nil
+}
+
+// Authenticate checks a plaintext token and returns its grant
+func Authenticate(ctx context.Context, s store.Store, raw string) (*Grant, error) {
+	id, secret, ok := split(raw)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+
+	data, err := s.Get(ctx, grantKeyPrefix+id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var grant Grant
+	if err := json.Unmarshal(data, &grant); err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(grant.Hash), []byte(secrets.Hash(secret))) != 1 {
+		return nil, ErrInvalidToken
+	}
+	if time.Now().After(grant.ExpiresAt) {
+		return nil, ErrInvalidToken
+	}
+	return &grant, nil
+}
+
+// Revoke ends the impersonation before it expires
+func Revoke(ctx context.Context, s store.Store, raw string) error {
+	grant, err := Authenticate(ctx, s, raw)
+	if err != nil {
+		return err
+	}
+	return s.Delete(ctx, grantKeyPrefix+grant.ID)
+}
+
+func split(raw string) (id, secret string, ok bool) {
+	if !strings.HasPrefix(raw, Prefix) {
+		return "", "", false
+	}
+	id, secret, ok = strings.Cut(strings.TrimPrefix(raw, Prefix), "_")
+	return id, secret, ok && id != "" && secret != ""
+}
@@ -0,0 +1,34 @@
+// Package secrets generates random identifiers and tokens and hashes secrets
+// for storage. Only the hash of a token handed to a client is ever stored.
+package secrets
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// Hash returns the hex SHA-256 of a secret, used as its lookup key
+func Hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// HexID returns n random bytes as hex, for public identifiers
+func HexID(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Token returns 32 random bytes, base64url encoded without padding
+func Token() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
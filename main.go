@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log"
 
 	"github.com/joho/godotenv"
@@ -9,6 +10,8 @@ import (
 	"github.com/skyapps-id/casdoor-test/config"
 	"github.com/skyapps-id/casdoor-test/handlers"
 	"github.com/skyapps-id/casdoor-test/middleware"
+	"github.com/skyapps-id/casdoor-test/problem"
+	"github.com/skyapps-id/casdoor-test/session"
 )
 
 func main() {
@@ -19,21 +22,61 @@ func main() {
 
 	// Initialize Casdoor
 	config.InitCasdoor()
+	config.InitAuth()
+	config.InitStore()
+	config.InitUserFields()
+	config.InitAPI()
+	config.InitValidation()
+	config.InitAccessRequests()
+
+	// Initialize authorization audit trail
+	config.InitAudit()
+	defer config.AuditLogger.Close()
+	defer config.CloseDatabases()
+
+	// Remove expired temporary role grants from Casdoor
+	handlers.StartGrantReaper(context.Background(), config.API.RoleGrantReapPeriod)
 
 	// Setup Echo
 	e := echo.New()
+	e.Validator = config.Validator
+	e.HTTPErrorHandler = problem.Handler
 
 	// Middleware
+	e.Use(echomiddleware.RequestID())
 	e.Use(echomiddleware.Logger())
 	e.Use(echomiddleware.Recover())
-	e.Use(echomiddleware.CORS())
+	if origins := config.GetEnvList("CORS_ALLOW_ORIGINS", nil); len(origins) > 0 {
+		// Explicit origins are required for cookie (session mode) requests
+		e.Use(echomiddleware.CORSWithConfig(echomiddleware.CORSConfig{
+			AllowOrigins:     origins,
+			AllowCredentials: true,
+			AllowHeaders: []string{
+				echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept,
+				echo.HeaderAuthorization, session.CSRFHeaderName, "If-Match",
+			},
+			ExposeHeaders: []string{"ETag", "Link", "X-Total-Count"},
+		}))
+	} else {
+		e.Use(echomiddleware.CORS())
+	}
 
 	// Public routes
 	e.GET("/login", handlers.GetLoginURL)
 	e.GET("/callback", handlers.HandleCallback)
+	e.POST("/auth/refresh", handlers.RefreshToken)
+	e.POST("/auth/logout", handlers.Logout)
+	e.POST("/auth/device", handlers.StartDeviceAuthorization)
+	e.POST("/auth/device/token", handlers.DeviceToken)
+	e.GET("/device", handlers.VerifyDevice)
+	e.POST("/device", handlers.ConfirmDevice)
 	e.GET("/health", handlers.HealthCheck)
 
+	// Sensitive operations need a recent (MFA) login
+	stepUp := middleware.RequireStepUp(config.Auth.StepUpMaxAge, config.Auth.StepUpRequireMFA)
+
 	// Protected routes
+	// Route-level RequireScopes only restricts tokens of third-party clients
 	api := e.Group("/api",
 		middleware.CasdoorAuthRequired(),
 		middleware.CasdoorRBAC(),
@@ -41,25 +84,61 @@ func main() {
 	{
 		// User info
 		api.GET("/me", handlers.GetCurrentUser)
+		api.PATCH("/me", handlers.UpdateCurrentUser, middleware.RequireScopes("profile:write"))
+		api.PUT("/me/password", handlers.ChangePassword, middleware.RequireScopes("profile:write"))
+
+		// Personal access tokens
+		api.GET("/me/tokens", handlers.ListPersonalTokens, middleware.RequireScopes("tokens:read"))
+		api.POST("/me/tokens", handlers.CreatePersonalToken, middleware.RequireScopes("tokens:write"))
+		api.DELETE("/me/tokens/:id", handlers.RevokePersonalToken, middleware.RequireScopes("tokens:write"))
 
 		// User management (requires permission)
-		api.GET("/users", handlers.ListUsers)
-		api.POST("/users", handlers.AddUser)
-		api.PUT("/users/:username", handlers.UpdateUser)
-		api.DELETE("/users/:username", handlers.DeleteUser)
+		api.GET("/users", handlers.ListUsers, middleware.RequireScopes("users:read"))
+		api.POST("/users", handlers.AddUser, middleware.RequireScopes("users:write"))
+		api.GET("/users/export", handlers.ExportUsers, middleware.RequireScopes("users:read"))
+		api.POST("/users/import", handlers.ImportUsers, middleware.RequireScopes("users:write"))
+		api.GET("/users/import/:id", handlers.GetImportJob, middleware.RequireScopes("users:write"))
+		api.GET("/users/:username", handlers.GetUser, middleware.RequireScopes("users:read"))
+		api.PUT("/users/:username", handlers.UpdateUser, middleware.RequireScopes("users:write"))
+		api.PATCH("/users/:username", handlers.PatchUser, middleware.RequireScopes("users:write"))
+		api.DELETE("/users/:username", handlers.DeleteUser, middleware.RequireScopes("users:write"), stepUp)
+		api.POST("/users/:username/impersonate", handlers.ImpersonateUser, middleware.RequireScopes("users:impersonate"))
 
 		// Role management (admin only)
-		api.GET("/roles", handlers.ListRoles)
-		api.POST("/roles", handlers.AddRole)
-		api.PUT("/roles/:role", handlers.UpdateRole)
-		api.DELETE("/roles/:role", handlers.DeleteRole)
+		api.GET("/roles", handlers.ListRoles, middleware.RequireScopes("roles:read"))
+		api.POST("/roles", handlers.AddRole, middleware.RequireScopes("roles:admin"))
+		api.GET("/roles/:role", handlers.GetRole, middleware.RequireScopes("roles:read"))
+		api.PUT("/roles/:role", handlers.UpdateRole, middleware.RequireScopes("roles:admin"), stepUp)
+		api.DELETE("/roles/:role", handlers.DeleteRole, middleware.RequireScopes("roles:admin"), stepUp)
+		api.POST("/roles/:role/members", handlers.AddRoleMembers, middleware.RequireScopes("roles:admin"), stepUp)
+		api.DELETE("/roles/:role/members", handlers.RemoveRoleMembers, middleware.RequireScopes("roles:admin"), stepUp)
 
 		// Assign role to user
-		api.POST("/users/:username/roles", handlers.AssignRole)
-		api.DELETE("/users/:username/roles/:role", handlers.RemoveRole)
+		api.POST("/users/:username/roles", handlers.AssignRole, middleware.RequireScopes("roles:admin"), stepUp)
+		api.PUT("/users/:username/roles", handlers.SetUserRoles, middleware.RequireScopes("roles:admin"), stepUp)
+		api.DELETE("/users/:username/roles/:role", handlers.RemoveRole, middleware.RequireScopes("roles:admin"), stepUp)
+
+		// Access requests, approvers per role come from ACCESS_APPROVERS_*
+		api.GET("/access-requests", handlers.ListAccessRequests, middleware.RequireScopes("access-requests:read"))
+		api.POST("/access-requests", handlers.CreateAccessRequest, middleware.RequireScopes("access-requests:write"))
+		api.GET("/access-requests/:id", handlers.GetAccessRequest, middleware.RequireScopes("access-requests:read"))
+		api.POST("/access-requests/:id/approve", handlers.ApproveAccessRequest, middleware.RequireScopes("access-requests:write"), stepUp)
+		api.POST("/access-requests/:id/deny", handlers.DenyAccessRequest, middleware.RequireScopes("access-requests:write"))
 
 		// RBAC sync
-		api.POST("/rbac/sync", handlers.SyncRBAC)
+		api.POST("/rbac/sync", handlers.SyncRBAC, middleware.RequireScopes("roles:admin"))
+
+		// Service accounts and API keys (admin only)
+		api.GET("/service-accounts", handlers.ListServiceAccounts, middleware.RequireScopes("service-accounts:admin"))
+		api.POST("/service-accounts", handlers.AddServiceAccount, middleware.RequireScopes("service-accounts:admin"))
+		api.DELETE("/service-accounts/:name", handlers.DeleteServiceAccount, middleware.RequireScopes("service-accounts:admin"))
+		api.GET("/service-accounts/:name/keys", handlers.ListAPIKeys, middleware.RequireScopes("service-accounts:admin"))
+		api.POST("/service-accounts/:name/keys", handlers.CreateAPIKey, middleware.RequireScopes("service-accounts:admin"))
+		api.POST("/service-accounts/:name/keys/:id/rotate", handlers.RotateAPIKey, middleware.RequireScopes("service-accounts:admin"))
+		api.DELETE("/service-accounts/:name/keys/:id", handlers.RevokeAPIKey, middleware.RequireScopes("service-accounts:admin"))
+
+		// Administrative change audit trail
+		api.GET("/audit", handlers.ListAuditLog, middleware.RequireScopes("audit:read"))
 	}
 
 	e.Logger.Fatal(e.Start(":9000"))
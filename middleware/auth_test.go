@@ -0,0 +1,24 @@
+package middleware
+
+import "testing"
+
+func TestNormalizeResource(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/api/users", "/api/users"},
+		{"/api/users/:username", "/api/users/*"},
+		{"/api/users/42", "/api/users/*"},
+		{"/api/users/:username/roles/:role", "/api/users/*/roles/*"},
+		{"/api/users/import", "/api/users/import"},
+		{"/api/access-requests/:id/approve", "/api/access-requests/*/approve"},
+		{"/api/me/tokens/7", "/api/me/tokens/*"},
+		{"/api", "/api"},
+	}
+	for _, tt := range tests {
+		if got := normalizeResource(tt.path); got != tt.want {
+			t.Errorf("normalizeResource(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/casdoor/casdoor-go-sdk/casdoorsdk"
+	"github.com/skyapps-id/casdoor-test/config"
+	"github.com/skyapps-id/casdoor-test/rolegrant"
+)
+
+// withoutExpiredRoles drops roles whose temporary grant has run out but that
+// the reaper has not removed from Casdoor yet. The user is copied, not changed.
+// Grants come from the short-lived cache, this runs on every request.
+func withoutExpiredRoles(ctx context.Context, user *casdoorsdk.User) (*casdoorsdk.User, error) {
+	grants, err := rolegrant.Cached(ctx, config.Store, user.Name)
+	if err != nil || len(grants) == 0 {
+		return user, err
+	}
+
+	now := time.Now()
+	active := []*casdoorsdk.Role{}
+	for _, role := range user.Roles {
+		if grant, ok := grants[role.Name]; ok && grant.Expired(now) {
+			continue
+		}
+		active = append(active, role)
+	}
+	if len(active) == len(user.Roles) {
+		return user, nil
+	}
+
+	filtered := *user
+	filtered.Roles = active
+	return &filtered, nil
+}
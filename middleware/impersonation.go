@@ -0,0 +1,37 @@
+package middleware
+
+import "strings"
+
+// impersonationBlocked lists the actions an admin cannot take while acting as
+// another user, even when the target's role allows them. Paths are prefixes of
+// the normalized resource, "*" matches any method.
+var impersonationBlocked = []struct {
+	Method string
+	Prefix string
+}{
+	{"DELETE", "/api/users/*"},
+	{"POST", "/api/users/import"},
+	{"*", "/api/users/*/roles"},
+	{"*", "/api/users/*/impersonate"},
+	{"PUT", "/api/me/password"},
+	{"POST", "/api/roles"},
+	{"PUT", "/api/roles"},
+	{"PATCH", "/api/roles"},
+	{"DELETE", "/api/roles"},
+	{"*", "/api/rbac/sync"},
+	{"*", "/api/service-accounts"},
+	{"POST", "/api/me/tokens"},
+	{"DELETE", "/api/me/tokens"},
+	{"POST", "/api/access-requests"},
+	{"*", "/api/access-requests/*/approve"},
+	{"*", "/api/access-requests/*/deny"},
+}
+
+func blockedWhileImpersonating(method, resource string) bool {
+	for _, rule := range impersonationBlocked {
+		if (rule.Method == "*" || rule.Method == method) && strings.HasPrefix(resource, rule.Prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/skyapps-id/casdoor-test/config"
+	"github.com/skyapps-id/casdoor-test/problem"
+	"github.com/skyapps-id/casdoor-test/session"
+	"github.com/skyapps-id/casdoor-test/store"
+)
+
+func TestRequestTokenCSRF(t *testing.T) {
+	config.Store = store.NewMemoryStore()
+	config.Auth.CookieSecret = []byte("secret")
+	config.Auth.TrustedOrigins = []string{"https://app.example.com"}
+
+	sess := &session.Session{ID: "s-1", AccessToken: "at", CSRFToken: "csrf-1", Expiry: time.Now().Add(time.Hour)}
+	if err := session.Save(context.Background(), config.Store, sess, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	cookie, err := session.EncodeCookie(config.Auth.CookieSecret, sess.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		cookie     string
+		headers    map[string]string
+		wantStatus int
+	}{
+		{"safe method needs no token", http.MethodGet, cookie, nil, 0},
+		{"unsafe without token", http.MethodPost, cookie, nil, http.StatusForbidden},
+		{"unsafe with wrong token", http.MethodDelete, cookie, map[string]string{"X-CSRF-Token": "csrf-2"}, http.StatusForbidden},
+		{"unsafe with token", http.MethodPost, cookie, map[string]string{"X-CSRF-Token": "csrf-1"}, 0},
+		{"same origin", http.MethodPatch, cookie, map[string]string{"X-CSRF-Token": "csrf-1", "Origin": "http://api.example.com"}, 0},
+		{"trusted origin", http.MethodPut, cookie, map[string]string{"X-CSRF-Token": "csrf-1", "Origin": "https://app.example.com"}, 0},
+		{"foreign origin", http.MethodPost, cookie, map[string]string{"X-CSRF-Token": "csrf-1", "Origin": "https://evil.com"}, http.StatusForbidden},
+		{"foreign referer", http.MethodPost, cookie, map[string]string{"X-CSRF-Token": "csrf-1", "Referer": "https://evil.com/form"}, http.StatusForbidden},
+		{"invalid cookie", http.MethodGet, "forged", nil, http.StatusUnauthorized},
+		{"unknown session", http.MethodGet, mustCookie(t, "s-2"), nil, http.StatusUnauthorized},
+		{"bearer skips the session", http.MethodPost, "", map[string]string{"Authorization": "Bearer at"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "http://api.example.com/api/users", nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: session.CookieName, Value: tt.cookie})
+			}
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			c := echo.New().NewContext(req, httptest.NewRecorder())
+
+			token, err := requestToken(c)
+			if tt.wantStatus == 0 {
+				if err != nil || token != "at" {
+					t.Fatalf("requestToken = %q, %v, want the access token", token, err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("requestToken succeeded, want %d", tt.wantStatus)
+			}
+			if got := problem.From(err).Status; got != tt.wantStatus {
+				t.Errorf("requestToken status = %d, want %d", got, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func mustCookie(t *testing.T, id string) string {
+	t.Helper()
+	value, err := session.EncodeCookie(config.Auth.CookieSecret, id, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
@@ -349,13 +349,78 @@ func (m *CasdoorMigration) MigratePolicies() error {
 		{"admin", "/api/users", "POST"},
 		{"admin", "/api/users/*", "GET"},
 		{"admin", "/api/users/*", "PUT"},
+		{"admin", "/api/users/*", "PATCH"},
 		{"admin", "/api/users/*", "DELETE"},
+		{"admin", "/api/users/*/impersonate", "POST"},
+		{"admin", "/api/users/import", "POST"},
+		{"admin", "/api/users/export", "GET"},
+		{"admin", "/api/users/import/*", "GET"},
 
 		{"manager", "/api/users", "GET"},
+		{"manager", "/api/users/*", "GET"},
 		{"manager", "/api/users/*", "PUT"},
+		{"manager", "/api/users/*", "PATCH"},
 
 		{"user", "/api/users", "GET"}, // user boleh list profiles
 
+		// ROLES permissions
+		{"admin", "/api/roles", "GET"},
+		{"admin", "/api/roles/*", "GET"},
+		{"admin", "/api/roles/*/members", "POST"},
+		{"admin", "/api/roles/*/members", "DELETE"},
+		{"admin", "/api/users/*/roles", "POST"},
+		{"admin", "/api/users/*/roles", "PUT"},
+		{"admin", "/api/users/*/roles/*", "DELETE"},
+
+		// SELF SERVICE permissions
+		{"admin", "/api/me", "GET"},
+		{"admin", "/api/me", "PATCH"},
+		{"admin", "/api/me/password", "PUT"},
+		{"manager", "/api/me", "GET"},
+		{"manager", "/api/me", "PATCH"},
+		{"manager", "/api/me/password", "PUT"},
+		{"user", "/api/me", "GET"},
+		{"user", "/api/me", "PATCH"},
+		{"user", "/api/me/password", "PUT"},
+
+		// PERSONAL ACCESS TOKEN permissions
+		{"admin", "/api/me/tokens", "GET"},
+		{"admin", "/api/me/tokens", "POST"},
+		{"admin", "/api/me/tokens/*", "DELETE"},
+		{"manager", "/api/me/tokens", "GET"},
+		{"manager", "/api/me/tokens", "POST"},
+		{"manager", "/api/me/tokens/*", "DELETE"},
+		{"user", "/api/me/tokens", "GET"},
+		{"user", "/api/me/tokens", "POST"},
+		{"user", "/api/me/tokens/*", "DELETE"},
+
+		// ACCESS REQUEST permissions, who may decide is checked per role by the handler
+		{"admin", "/api/access-requests", "GET"},
+		{"admin", "/api/access-requests", "POST"},
+		{"admin", "/api/access-requests/*", "GET"},
+		{"admin", "/api/access-requests/*/approve", "POST"},
+		{"admin", "/api/access-requests/*/deny", "POST"},
+		{"manager", "/api/access-requests", "GET"},
+		{"manager", "/api/access-requests", "POST"},
+		{"manager", "/api/access-requests/*", "GET"},
+		{"manager", "/api/access-requests/*/approve", "POST"},
+		{"manager", "/api/access-requests/*/deny", "POST"},
+		{"user", "/api/access-requests", "GET"},
+		{"user", "/api/access-requests", "POST"},
+		{"user", "/api/access-requests/*", "GET"},
+
+		// AUDIT permissions
+		{"admin", "/api/audit", "GET"},
+
+		// SERVICE ACCOUNT permissions
+		{"admin", "/api/service-accounts", "GET"},
+		{"admin", "/api/service-accounts", "POST"},
+		{"admin", "/api/service-accounts/*", "DELETE"},
+		{"admin", "/api/service-accounts/*/keys", "GET"},
+		{"admin", "/api/service-accounts/*/keys", "POST"},
+		{"admin", "/api/service-accounts/*/keys/*/rotate", "POST"},
+		{"admin", "/api/service-accounts/*/keys/*", "DELETE"},
+
 		// PRODUCTS permissions
 		{"admin", "/api/products", "GET"},
 		{"admin", "/api/products", "POST"},
@@ -0,0 +1,189 @@
+package pat
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/skyapps-id/casdoor-test/internal/secrets"
+	"github.com/skyapps-id/casdoor-test/store"
+)
+
+const (
+	Prefix = "pat_"
+
+	tokenKeyPrefix = "pat:"
+)
+
+var (
+	ErrNotFound     = errors.New("pat: not found")
+	ErrInvalidToken = errors.New("pat: invalid token")
+)
+
+// Token is a personal access token acting as its owner, restricted to Scopes.
+// Only the SHA-256 hash of the secret is stored.
+type Token struct {
+	ID         string     `json:"id"`
+	Owner      string     `json:"owner"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	Hash       string     `json:"hash"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// TokenView is the public representation of a token (no hash)
+type TokenView struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+func (t *Token) View() TokenView {
+	return TokenView{
+		ID:         t.ID,
+		Name:       t.Name,
+		Scopes:     t.Scopes,
+		CreatedAt:  t.CreatedAt,
+		ExpiresAt:  t.ExpiresAt,
+		LastUsedAt: t.LastUsedAt,
+	}
+}
+
+// Create mints a token for owner. The plaintext is only returned here.
+func Create(ctx context.Context, s store.Store, owner, name string, scopes []string, ttl time.Duration) (*Token, string, error) {
+	id := secrets.HexID(8)
+	secret := secrets.Token()
+	now := time.Now().UTC()
+
+	token := &Token{
+		ID:        id,
+		Owner:     owner,
+		Name:      name,
+		Scopes:    scopes,
+		Hash:      secrets.Hash(secret),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if err := save(ctx, s, token); err != nil {
+		return nil, "", err
+	}
+	return token, Prefix + id + "_" + secret, nil
+}
+
+func Get(ctx context.Context, s store.Store, owner, id string) (*Token, error) {
+	data, err := s.Get(ctx, tokenKeyPrefix+id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var token Token
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, err
+	}
+	if owner != "" && token.Owner != owner {
+		return nil, ErrNotFound
+	}
+	return &token, nil
+}
+
+// List returns the live tokens of owner, oldest first
+func List(ctx context.Context, s store.Store, owner string) ([]*Token, error) {
+	items, err := s.List(ctx, tokenKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := []*Token{}
+	for _, data := range items {
+		var token Token
+		if err := json.Unmarshal(data, &token); err != nil {
+			return nil, err
+		}
+		if token.Owner == owner {
+			tokens = append(tokens, &token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.Before(tokens[j].CreatedAt) })
+	return tokens, nil
+}
+
+// Revoke deletes a token of owner
+func Revoke(ctx context.Context, s store.Store, owner, id string) error {
+	if _, err := Get(ctx, s, owner, id); err != nil {
+		return err
+	}
+	return s.Delete(ctx, tokenKeyPrefix+id)
+}
+
+// Authenticate checks a plaintext token and returns it, updating last-used
+func Authenticate(ctx context.Context, s store.Store, raw string) (*Token, error) {
+	if !strings.HasPrefix(raw, Prefix) {
+		return nil, ErrInvalidToken
+	}
+	id, secret, ok := strings.Cut(strings.TrimPrefix(raw, Prefix), "_")
+	if !ok || id == "" || secret == "" {
+		return nil, ErrInvalidToken
+	}
+
+	token, err := Get(ctx, s, "", id)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(token.Hash), []byte(secrets.Hash(secret))) != 1 {
+		return nil, ErrInvalidToken
+	}
+	if time.Now().After(token.ExpiresAt) {
+		return nil, ErrInvalidToken
+	}
+
+	now := time.Now().UTC()
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > time.Minute {
+		token.LastUsedAt = &now
+		_ = save(ctx, s, token)
+	}
+	return token, nil
+}
+
+// ParseScope splits a "METHOD /path" scope, method and path may be "*"
+func ParseScope(scope string) (method, path string, ok bool) {
+	method, path, ok = strings.Cut(strings.TrimSpace(scope), " ")
+	path = strings.TrimSpace(path)
+	return strings.ToUpper(method), path, ok && method != "" && path != ""
+}
+
+// Allows reports whether one of the scopes covers method on the normalized resource
+func Allows(scopes []string, method, resource string) bool {
+	for _, scope := range scopes {
+		m, p, ok := ParseScope(scope)
+		if !ok {
+			continue
+		}
+		if (m == "*" || m == method) && (p == "*" || p == resource) {
+			return true
+		}
+	}
+	return false
+}
+
+func save(ctx context.Context, s store.Store, token *Token) error {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	return s.Set(ctx, tokenKeyPrefix+token.ID, data, time.Until(token.ExpiresAt))
+}
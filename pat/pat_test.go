@@ -0,0 +1,32 @@
+package pat
+
+import "testing"
+
+func TestAllows(t *testing.T) {
+	tests := []struct {
+		name     string
+		scopes   []string
+		method   string
+		resource string
+		want     bool
+	}{
+		{"exact match", []string{"GET /api/users"}, "GET", "/api/users", true},
+		{"lowercase method", []string{"get /api/users"}, "GET", "/api/users", true},
+		{"other method", []string{"GET /api/users"}, "POST", "/api/users", false},
+		{"other path", []string{"GET /api/users"}, "GET", "/api/roles", false},
+		{"no prefix match", []string{"GET /api/users"}, "GET", "/api/users/*", false},
+		{"wildcard resource", []string{"GET /api/users/*"}, "GET", "/api/users/*", true},
+		{"any method", []string{"* /api/me"}, "PATCH", "/api/me", true},
+		{"any path", []string{"GET *"}, "GET", "/api/roles", true},
+		{"second scope", []string{"GET /api/roles", "DELETE /api/users/*"}, "DELETE", "/api/users/*", true},
+		{"malformed scope", []string{"GET"}, "GET", "/api/users", false},
+		{"no scopes", nil, "GET", "/api/users", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Allows(tt.scopes, tt.method, tt.resource); got != tt.want {
+				t.Errorf("Allows(%q, %s, %s) = %v, want %v", tt.scopes, tt.method, tt.resource, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package problem
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/skyapps-id/casdoor-test/validation"
+)
+
+// Handler is the Echo HTTPErrorHandler rendering every error as problem+json:
+// type, title, status, detail, instance plus the code, request_id, field
+// errors and any extension members of the problem
+func Handler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	p := From(err)
+	requestID := c.Response().Header().Get(echo.HeaderXRequestID)
+	if p.Status >= 500 || p.Err != nil {
+		log.Printf("[%s] %s %s: %v", requestID, c.Request().Method, c.Request().URL.Path, err)
+	}
+
+	doc := map[string]interface{}{}
+	for k, v := range p.Extensions {
+		doc[k] = v
+	}
+	doc["type"] = "about:blank"
+	doc["title"] = http.StatusText(p.Status)
+	doc["status"] = p.Status
+	doc["code"] = p.Code
+	doc["instance"] = c.Request().URL.Path
+	if p.Detail != "" {
+		doc["detail"] = p.Detail
+	}
+	if requestID != "" {
+		doc["request_id"] = requestID
+	}
+	if len(p.Fields) > 0 {
+		doc["errors"] = p.Fields
+	}
+
+	if c.Request().Method == http.MethodHead {
+		_ = c.NoContent(p.Status)
+		return
+	}
+	c.Response().Header().Set(echo.HeaderContentType, ContentType)
+	_ = c.JSON(p.Status, doc)
+}
+
+// From converts any error into a problem
+func From(err error) *Error {
+	var p *Error
+	if errors.As(err, &p) {
+		return p
+	}
+
+	var fields validation.Errors
+	if errors.As(err, &fields) {
+		return Validation(fields)
+	}
+
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		if inner, ok := he.Internal.(*echo.HTTPError); ok {
+			he = inner
+		}
+		p = New(he.Code, CodeForStatus(he.Code), "")
+		switch m := he.Message.(type) {
+		case string:
+			p.Detail = m
+		case map[string]interface{}:
+			for k, v := range m {
+				p.With(k, v)
+			}
+		}
+		return p.Wrap(he.Internal)
+	}
+
+	return Internal(http.StatusText(http.StatusInternalServerError), err)
+}
@@ -0,0 +1,160 @@
+package problem
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/skyapps-id/casdoor-test/validation"
+)
+
+// ContentType of RFC 7807 responses
+const ContentType = "application/problem+json"
+
+// Stable error codes, clients should switch on these rather than on detail texts
+const (
+	CodeInvalidRequest       = "invalid_request"
+	CodeValidationFailed     = "validation_failed"
+	CodeUnauthorized         = "unauthorized"
+	CodeTokenRevoked         = "token_revoked"
+	CodeStepUpRequired       = "step_up_required"
+	CodeForbidden            = "forbidden"
+	CodeNoRole               = "no_role_assigned"
+	CodeInsufficientScope    = "insufficient_scope"
+	CodeNotFound             = "not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeConflict             = "conflict"
+	CodePreconditionFailed   = "precondition_failed"
+	CodePreconditionRequired = "precondition_required"
+	CodeTooLarge             = "request_too_large"
+	CodeInternal             = "internal_error"
+	CodeUpstreamError        = "upstream_error"
+	CodeUpstreamUnavailable  = "upstream_unavailable"
+)
+
+// Error is the central API error. The handler renders it as problem+json;
+// Err is the cause, logged but never shown to clients.
+type Error struct {
+	Status     int
+	Code       string
+	Detail     string
+	Fields     []validation.FieldError
+	Extensions map[string]interface{}
+	Err        error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Code + ": " + e.Detail + ": " + e.Err.Error()
+	}
+	return e.Code + ": " + e.Detail
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Wrap records the underlying cause
+func (e *Error) Wrap(err error) *Error {
+	e.Err = err
+	return e
+}
+
+// With adds an extension member to the problem document
+func (e *Error) With(key string, value interface{}) *Error {
+	if e.Extensions == nil {
+		e.Extensions = map[string]interface{}{}
+	}
+	e.Extensions[key] = value
+	return e
+}
+
+func New(status int, code, detail string) *Error {
+	return &Error{Status: status, Code: code, Detail: detail}
+}
+
+func BadRequest(detail string) *Error {
+	return New(http.StatusBadRequest, CodeInvalidRequest, detail)
+}
+
+func Unauthorized(detail string) *Error {
+	return New(http.StatusUnauthorized, CodeUnauthorized, detail)
+}
+
+func Forbidden(detail string) *Error {
+	return New(http.StatusForbidden, CodeForbidden, detail)
+}
+
+func NotFound(detail string) *Error {
+	return New(http.StatusNotFound, CodeNotFound, detail)
+}
+
+func Conflict(detail string) *Error {
+	return New(http.StatusConflict, CodeConflict, detail)
+}
+
+func Internal(detail string, err error) *Error {
+	return New(http.StatusInternalServerError, CodeInternal, detail).Wrap(err)
+}
+
+// Validation reports field level errors
+func Validation(fields validation.Errors) *Error {
+	e := New(http.StatusBadRequest, CodeValidationFailed, "Request validation failed")
+	e.Fields = fields
+	return e
+}
+
+// Casdoor maps a failed Casdoor call: unreachable → 503, missing → 404,
+// duplicate → 409, anything else → 502. detail describes the operation; the
+// upstream message stays in Err, it can leak Casdoor internals.
+func Casdoor(err error, detail string) *Error {
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) {
+		return New(http.StatusServiceUnavailable, CodeUpstreamUnavailable, detail+": Casdoor is unreachable").Wrap(err)
+	}
+
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "connection refused"), strings.Contains(msg, "no such host"):
+		return New(http.StatusServiceUnavailable, CodeUpstreamUnavailable, detail+": Casdoor is unreachable").Wrap(err)
+	case strings.Contains(msg, "not found"), strings.Contains(msg, "not exist"), strings.Contains(msg, "doesn't exist"):
+		return New(http.StatusNotFound, CodeNotFound, detail+": not found in Casdoor").Wrap(err)
+	case strings.Contains(msg, "already exist"), strings.Contains(msg, "duplicate"), strings.Contains(msg, "unique"):
+		return New(http.StatusConflict, CodeConflict, detail+": already exists in Casdoor").Wrap(err)
+	}
+	return New(http.StatusBadGateway, CodeUpstreamError, detail+": Casdoor rejected the request").Wrap(err)
+}
+
+// CodeForStatus is the default code of errors raised without one
+func CodeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeInvalidRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusPreconditionFailed:
+		return CodePreconditionFailed
+	case http.StatusPreconditionRequired:
+		return CodePreconditionRequired
+	case http.StatusRequestEntityTooLarge:
+		return CodeTooLarge
+	case http.StatusBadGateway:
+		return CodeUpstreamError
+	case http.StatusServiceUnavailable:
+		return CodeUpstreamUnavailable
+	}
+	if status >= 500 {
+		return CodeInternal
+	}
+	return strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+}
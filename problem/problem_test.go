@@ -0,0 +1,71 @@
+package problem
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/skyapps-id/casdoor-test/validation"
+)
+
+func TestFrom(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+		wantDetail string
+	}{
+		{"problem", NotFound("User not found"), http.StatusNotFound, CodeNotFound, "User not found"},
+		{"wrapped problem", fmt.Errorf("loading: %w", Conflict("Busy")), http.StatusConflict, CodeConflict, "Busy"},
+		{"validation errors", validation.Errors{{Field: "email", Rule: "email", Message: "must be a valid email address"}},
+			http.StatusBadRequest, CodeValidationFailed, "Request validation failed"},
+		{"echo error", echo.NewHTTPError(http.StatusMethodNotAllowed, "Method Not Allowed"),
+			http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method Not Allowed"},
+		{"echo error without text", echo.ErrStatusRequestEntityTooLarge, http.StatusRequestEntityTooLarge, CodeTooLarge, "Request Entity Too Large"},
+		{"unknown error", errors.New("boom"), http.StatusInternalServerError, CodeInternal, "Internal Server Error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := From(tt.err)
+			if p.Status != tt.wantStatus || p.Code != tt.wantCode || p.Detail != tt.wantDetail {
+				t.Errorf("From(%v) = %d %s %q, want %d %s %q", tt.err, p.Status, p.Code, p.Detail, tt.wantStatus, tt.wantCode, tt.wantDetail)
+			}
+		})
+	}
+}
+
+func TestFromKeepsCause(t *testing.T) {
+	cause := errors.New("boom")
+	if p := From(cause); !errors.Is(p, cause) {
+		t.Errorf("From(err).Err = %v, want the original error", p.Err)
+	}
+}
+
+func TestCasdoor(t *testing.T) {
+	tests := []struct {
+		err        string
+		wantStatus int
+	}{
+		{"dial tcp 127.0.0.1:8000: connect: connection refused", http.StatusServiceUnavailable},
+		{"the user: skyapps/bob doesn't exist", http.StatusNotFound},
+		{"Error 1062: Duplicate entry 'skyapps-bob'", http.StatusConflict},
+		{"invalid application or wrong clientSecret", http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		p := Casdoor(errors.New(tt.err), "Failed to update user")
+		if p.Status != tt.wantStatus {
+			t.Errorf("Casdoor(%q).Status = %d, want %d", tt.err, p.Status, tt.wantStatus)
+		}
+		// Upstream text is kept for the log only
+		if p.Err == nil || p.Err.Error() != tt.err {
+			t.Errorf("Casdoor(%q).Err = %v", tt.err, p.Err)
+		}
+		if !strings.HasPrefix(p.Detail, "Failed to update user: ") || strings.Contains(p.Detail, tt.err) {
+			t.Errorf("Casdoor(%q).Detail = %q", tt.err, p.Detail)
+		}
+	}
+}
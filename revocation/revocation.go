@@ -0,0 +1,44 @@
+package revocation
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/skyapps-id/casdoor-test/store"
+)
+
+const keyPrefix = "revoked-jti:"
+
+// Revoke adds a token id to the deny list until the token would have expired
+// anyway. Backed by store.Store so every instance sharing the store sees it.
+func Revoke(ctx context.Context, s store.Store, jti string, expiresAt time.Time) error {
+	if jti == "" {
+		return errors.New("revocation: token has no jti")
+	}
+
+	ttl := time.Until(expiresAt)
+	if expiresAt.IsZero() {
+		ttl = 0
+	} else if ttl <= 0 {
+		// already expired, nothing to deny
+		return nil
+	}
+	return s.Set(ctx, keyPrefix+jti, []byte("1"), ttl)
+}
+
+// IsRevoked reports whether the token id is on the deny list
+func IsRevoked(ctx context.Context, s store.Store, jti string) (bool, error) {
+	if jti == "" {
+		return false, nil
+	}
+
+	_, err := s.Get(ctx, keyPrefix+jti)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
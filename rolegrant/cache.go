@@ -0,0 +1,58 @@
+package rolegrant
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/skyapps-id/casdoor-test/store"
+)
+
+// CacheTTL is how long Cached keeps a user's grants. Expiry is still checked
+// against the cached ExpiresAt, so a stale entry only misses grants created or
+// removed on another instance within that window.
+var CacheTTL = 30 * time.Second
+
+// Entries are dropped all at once past this size, a simple bound on memory
+const cacheMaxUsers = 10000
+
+type cacheEntry struct {
+	grants  map[string]*Grant
+	expires time.Time
+}
+
+var cache = struct {
+	sync.Mutex
+	users map[string]cacheEntry
+}{users: map[string]cacheEntry{}}
+
+// Cached returns ForUser, served from memory for CacheTTL. It is meant for the
+// per-request role check; Save and Delete on this instance invalidate it.
+func Cached(ctx context.Context, s store.Store, username string) (map[string]*Grant, error) {
+	now := time.Now()
+	cache.Lock()
+	entry, ok := cache.users[username]
+	cache.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.grants, nil
+	}
+
+	grants, err := ForUser(ctx, s, username)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.Lock()
+	if len(cache.users) >= cacheMaxUsers {
+		cache.users = map[string]cacheEntry{}
+	}
+	cache.users[username] = cacheEntry{grants: grants, expires: now.Add(CacheTTL)}
+	cache.Unlock()
+	return grants, nil
+}
+
+func invalidate(username string) {
+	cache.Lock()
+	delete(cache.users, username)
+	cache.Unlock()
+}
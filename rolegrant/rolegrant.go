@@ -0,0 +1,155 @@
+package rolegrant
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/skyapps-id/casdoor-test/store"
+)
+
+const keyPrefix = "role-grant:"
+
+var ErrNotFound = errors.New("rolegrant: grant not found")
+
+// Grant marks a role assignment as temporary. The membership itself lives in
+// Casdoor; the grant only records when it has to be taken away again.
+type Grant struct {
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	GrantedBy string    `json:"granted_by"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Expired reports whether the grant no longer counts at now
+func (g *Grant) Expired(now time.Time) bool {
+	return !now.Before(g.ExpiresAt)
+}
+
+// Save stores the grant without a store TTL, it stays until the reaper has
+// removed the role from Casdoor and deletes it
+func Save(ctx context.Context, s store.Store, g *Grant) error {
+	data, err := json.Marshal(g)
+	if err != nil {
+		return err
+	}
+	defer invalidate(g.Username)
+	return s.Set(ctx, key(g.Username, g.Role), data, 0)
+}
+
+// Get returns the grant of role for username
+func Get(ctx context.Context, s store.Store, username, role string) (*Grant, error) {
+	data, err := s.Get(ctx, key(username, role))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var g Grant
+	if err := json.Unmarshal(data, &g); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// Delete removes a grant, the assignment becomes permanent or is already gone
+func Delete(ctx context.Context, s store.Store, username, role string) error {
+	defer invalidate(username)
+	return s.Delete(ctx, key(username, role))
+}
+
+// StillExpired re-reads g and reports whether it is still stored with the
+// same expiry and has run out at now. The reaper works from a list read
+// earlier; a grant renewed, extended or made permanent since must be kept.
+func StillExpired(ctx context.Context, s store.Store, g *Grant, now time.Time) (bool, error) {
+	current, err := Get(ctx, s, g.Username, g.Role)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return current.ExpiresAt.Equal(g.ExpiresAt) && current.Expired(now), nil
+}
+
+// DeleteIfUnchanged deletes g only while the store holds it with the same
+// expiry, and reports whether it did
+func DeleteIfUnchanged(ctx context.Context, s store.Store, g *Grant) (bool, error) {
+	defer invalidate(g.Username)
+
+	data, err := s.Get(ctx, key(g.Username, g.Role))
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var current Grant
+	if err := json.Unmarshal(data, &current); err != nil {
+		return false, err
+	}
+	if !current.ExpiresAt.Equal(g.ExpiresAt) {
+		return false, nil
+	}
+	// Conditional on the exact value read, a Save in between wins
+	return s.DeleteIf(ctx, key(g.Username, g.Role), data)
+}
+
+// ForUser returns the user's grants keyed by role
+func ForUser(ctx context.Context, s store.Store, username string) (map[string]*Grant, error) {
+	grants, err := list(ctx, s, keyPrefix+username+":")
+	if err != nil {
+		return nil, err
+	}
+
+	byRole := map[string]*Grant{}
+	for _, g := range grants {
+		// "_" in usernames is a wildcard for the SQL store, check the match
+		if g.Username == username {
+			byRole[g.Role] = g
+		}
+	}
+	return byRole, nil
+}
+
+// Expired returns every grant that has run out at now
+func Expired(ctx context.Context, s store.Store, now time.Time) ([]*Grant, error) {
+	grants, err := list(ctx, s, keyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	expired := []*Grant{}
+	for _, g := range grants {
+		if g.Expired(now) {
+			expired = append(expired, g)
+		}
+	}
+	return expired, nil
+}
+
+func list(ctx context.Context, s store.Store, prefix string) ([]*Grant, error) {
+	items, err := s.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	grants := make([]*Grant, 0, len(items))
+	for _, data := range items {
+		var g Grant
+		if err := json.Unmarshal(data, &g); err != nil {
+			continue
+		}
+		grants = append(grants, &g)
+	}
+	return grants, nil
+}
+
+func key(username, role string) string {
+	return keyPrefix + username + ":" + role
+}
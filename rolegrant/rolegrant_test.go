@@ -0,0 +1,150 @@
+package rolegrant
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/skyapps-id/casdoor-test/store"
+)
+
+func TestGrantExpired(t *testing.T) {
+	expires := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	g := &Grant{ExpiresAt: expires}
+
+	tests := []struct {
+		now  time.Time
+		want bool
+	}{
+		{expires.Add(-time.Second), false},
+		{expires, true},
+		{expires.Add(time.Second), true},
+	}
+	for _, tt := range tests {
+		if got := g.Expired(tt.now); got != tt.want {
+			t.Errorf("Expired(%s) = %v, want %v", tt.now, got, tt.want)
+		}
+	}
+}
+
+func TestExpiredAndForUser(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemoryStore()
+	now := time.Now()
+
+	grants := []*Grant{
+		{Username: "alice", Role: "admin", ExpiresAt: now.Add(-time.Minute)},
+		{Username: "alice", Role: "on-call", ExpiresAt: now.Add(time.Hour)},
+		{Username: "alice_b", Role: "admin", ExpiresAt: now.Add(-time.Minute)},
+		{Username: "bob", Role: "admin", ExpiresAt: now.Add(time.Hour)},
+	}
+	for _, g := range grants {
+		if err := Save(ctx, s, g); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expired, err := Expired(ctx, s, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]bool{}
+	for _, g := range expired {
+		got[g.Username+"/"+g.Role] = true
+	}
+	if len(got) != 2 || !got["alice/admin"] || !got["alice_b/admin"] {
+		t.Errorf("Expired = %v, want alice/admin and alice_b/admin", got)
+	}
+
+	byRole, err := ForUser(ctx, s, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(byRole) != 2 || byRole["admin"] == nil || byRole["on-call"] == nil {
+		t.Errorf("ForUser(alice) = %v, want admin and on-call", byRole)
+	}
+
+	if err := Delete(ctx, s, "alice", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Get(ctx, s, "alice", "admin"); err != ErrNotFound {
+		t.Errorf("Get after Delete error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestCachedIsInvalidated(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemoryStore()
+
+	if grants, err := Cached(ctx, s, "carol"); err != nil || len(grants) != 0 {
+		t.Fatalf("Cached(carol) = %v, %v, want no grants", grants, err)
+	}
+	if err := Save(ctx, s, &Grant{Username: "carol", Role: "admin", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if grants, _ := Cached(ctx, s, "carol"); grants["admin"] == nil {
+		t.Errorf("Cached(carol) after Save = %v, want the admin grant", grants)
+	}
+	if err := Delete(ctx, s, "carol", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if grants, _ := Cached(ctx, s, "carol"); len(grants) != 0 {
+		t.Errorf("Cached(carol) after Delete = %v, want no grants", grants)
+	}
+}
+
+func TestReapSkipsExtendedGrant(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemoryStore()
+	now := time.Now()
+
+	if err := Save(ctx, s, &Grant{Username: "dave", Role: "on-call", ExpiresAt: now.Add(-time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	listed, err := Expired(ctx, s, now)
+	if err != nil || len(listed) != 1 {
+		t.Fatalf("Expired = %v, %v, want one grant", listed, err)
+	}
+
+	// assignRole extends the grant after the reaper listed it
+	extended := &Grant{Username: "dave", Role: "on-call", ExpiresAt: now.Add(time.Hour)}
+	if err := Save(ctx, s, extended); err != nil {
+		t.Fatal(err)
+	}
+
+	if expired, err := StillExpired(ctx, s, listed[0], now); err != nil || expired {
+		t.Errorf("StillExpired after the extension = %v, %v, want false", expired, err)
+	}
+	if deleted, err := DeleteIfUnchanged(ctx, s, listed[0]); err != nil || deleted {
+		t.Errorf("DeleteIfUnchanged after the extension = %v, %v, want false", deleted, err)
+	}
+	current, err := Get(ctx, s, "dave", "on-call")
+	if err != nil || !current.ExpiresAt.Equal(extended.ExpiresAt) {
+		t.Errorf("Get = %+v, %v, want the extended grant", current, err)
+	}
+}
+
+func TestReapRemovesUnchangedGrant(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemoryStore()
+	now := time.Now()
+
+	if err := Save(ctx, s, &Grant{Username: "erin", Role: "on-call", ExpiresAt: now.Add(-time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	listed, _ := Expired(ctx, s, now)
+
+	if expired, err := StillExpired(ctx, s, listed[0], now); err != nil || !expired {
+		t.Errorf("StillExpired = %v, %v, want true", expired, err)
+	}
+	if deleted, err := DeleteIfUnchanged(ctx, s, listed[0]); err != nil || !deleted {
+		t.Errorf("DeleteIfUnchanged = %v, %v, want true", deleted, err)
+	}
+	if _, err := Get(ctx, s, "erin", "on-call"); err != ErrNotFound {
+		t.Errorf("Get after reaping error = %v, want ErrNotFound", err)
+	}
+	// Made permanent (grant deleted) after listing
+	if expired, _ := StillExpired(ctx, s, listed[0], now); expired {
+		t.Error("StillExpired reported a deleted grant as expired")
+	}
+}
@@ -0,0 +1,79 @@
+package securecookie
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+)
+
+// Encrypt is like Encode but also hides the value, using AES-256-GCM with a
+// key derived from secret. The cookie name is bound as additional data.
+func Encrypt(secret []byte, name string, v interface{}, ttl time.Duration) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(envelope{Exp: time.Now().Add(ttl).Unix(), Data: data})
+	if err != nil {
+		return "", err
+	}
+
+	aead, err := newAEAD(secret)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, payload, []byte(name))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt reverses Encrypt, checking integrity and expiry
+func Decrypt(secret []byte, name, value string, v interface{}) error {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return ErrInvalid
+	}
+
+	aead, err := newAEAD(secret)
+	if err != nil {
+		return err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return ErrInvalid
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	payload, err := aead.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil {
+		return ErrInvalid
+	}
+
+	var env envelope
+	if err := json.Unmarshal(payload, &env); err != nil {
+		return ErrInvalid
+	}
+	if time.Now().Unix() > env.Exp {
+		return ErrExpired
+	}
+
+	return json.Unmarshal(env.Data, v)
+}
+
+func newAEAD(secret []byte) (cipher.AEAD, error) {
+	key := sha256.Sum256(secret)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
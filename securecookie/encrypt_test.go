@@ -0,0 +1,62 @@
+package securecookie
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEncrypt(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	valid, err := Encrypt(secret, "session", "abc123", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := Encrypt(secret, "session", "abc123", -2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, _ := base64.RawURLEncoding.DecodeString(valid)
+	raw[len(raw)-1] ^= 0x01
+	tampered := base64.RawURLEncoding.EncodeToString(raw)
+
+	tests := []struct {
+		name    string
+		secret  []byte
+		cookie  string
+		value   string
+		wantErr error
+	}{
+		{"round trip", secret, "session", valid, nil},
+		{"tampered", secret, "session", tampered, ErrInvalid},
+		{"other cookie name", secret, "csrf_token", valid, ErrInvalid},
+		{"other secret", []byte("another secret"), "session", valid, ErrInvalid},
+		{"expired", secret, "session", expired, ErrExpired},
+		{"not base64", secret, "session", "%%%", ErrInvalid},
+		{"too short", secret, "session", "AAAA", ErrInvalid},
+		{"empty", secret, "session", "", ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			err := Decrypt(tt.secret, tt.cookie, tt.value, &got)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Decrypt error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && got != "abc123" {
+				t.Errorf("Decrypt = %q, want abc123", got)
+			}
+		})
+	}
+}
+
+func TestEncryptUsesFreshNonce(t *testing.T) {
+	secret := []byte("secret")
+	a, _ := Encrypt(secret, "session", "abc123", time.Hour)
+	b, _ := Encrypt(secret, "session", "abc123", time.Hour)
+	if a == b {
+		t.Error("Encrypt returned the same value twice, the nonce must be random")
+	}
+}
@@ -0,0 +1,72 @@
+package securecookie
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalid = errors.New("securecookie: invalid value")
+	ErrExpired = errors.New("securecookie: expired value")
+)
+
+type envelope struct {
+	Exp  int64           `json:"exp"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Encode serializes v as JSON and signs it with HMAC-SHA256.
+// The cookie name is part of the MAC so a value cannot be replayed under another cookie.
+func Encode(secret []byte, name string, v interface{}, ttl time.Duration) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(envelope{Exp: time.Now().Add(ttl).Unix(), Data: data})
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + sign(secret, name, encoded), nil
+}
+
+// Decode verifies the signature and expiry of value and unmarshals it into v
+func Decode(secret []byte, name, value string, v interface{}) error {
+	encoded, mac, ok := strings.Cut(value, ".")
+	if !ok {
+		return ErrInvalid
+	}
+	if !hmac.Equal([]byte(mac), []byte(sign(secret, name, encoded))) {
+		return ErrInvalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return ErrInvalid
+	}
+
+	var env envelope
+	if err := json.Unmarshal(payload, &env); err != nil {
+		return ErrInvalid
+	}
+	if time.Now().Unix() > env.Exp {
+		return ErrExpired
+	}
+
+	return json.Unmarshal(env.Data, v)
+}
+
+func sign(secret []byte, name, encoded string) string {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
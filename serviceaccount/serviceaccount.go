@@ -0,0 +1,323 @@
+package serviceaccount
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/skyapps-id/casdoor-test/internal/secrets"
+	"github.com/skyapps-id/casdoor-test/store"
+)
+
+const (
+	KeyPrefix = "sk_"
+
+	accountKeyPrefix = "service-account:"
+	clientKeyPrefix  = "service-account-client:"
+	apiKeyKeyPrefix  = "api-key:"
+
+	// lastUsedResolution limits how often a key's last-used timestamp is written
+	lastUsedResolution = time.Minute
+)
+
+var (
+	ErrNotFound = errors.New("serviceaccount: not found")
+	ErrExists   = errors.New("serviceaccount: already exists")
+	// ErrClientIDInUse is an ErrExists for a client id mapped to another account
+	ErrClientIDInUse = fmt.Errorf("%w: client id is mapped to another account", ErrExists)
+	ErrInvalidKey    = errors.New("serviceaccount: invalid api key")
+)
+
+// Account is a non-human identity used by batch jobs and other services
+type Account struct {
+	Name      string    `json:"name"`
+	Roles     []string  `json:"roles"`
+	ClientID  string    `json:"client_id,omitempty"` // Casdoor application for client-credentials tokens
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Key is a long-lived API key, only its SHA-256 hash is stored
+type Key struct {
+	ID         string     `json:"id"`
+	Account    string     `json:"account"`
+	Name       string     `json:"name"`
+	Hash       string     `json:"hash"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RotatedAt  *time.Time `json:"rotated_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// KeyView is the public representation of a key (no hash)
+type KeyView struct {
+	ID         string     `json:"id"`
+	Account    string     `json:"account"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RotatedAt  *time.Time `json:"rotated_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+func (k *Key) View() KeyView {
+	return KeyView{
+		ID:         k.ID,
+		Account:    k.Account,
+		Name:       k.Name,
+		CreatedAt:  k.CreatedAt,
+		RotatedAt:  k.RotatedAt,
+		LastUsedAt: k.LastUsedAt,
+	}
+}
+
+// Create registers a new service account. A client id can only be mapped to
+// one account, it is reserved before the account is written.
+func Create(ctx context.Context, s store.Store, account *Account) error {
+	account.CreatedAt = time.Now().UTC()
+	data, err := json.Marshal(account)
+	if err != nil {
+		return err
+	}
+
+	if account.ClientID != "" {
+		reserved, err := s.SetNX(ctx, clientKeyPrefix+account.ClientID, []byte(account.Name), 0)
+		if err != nil {
+			return err
+		}
+		if !reserved {
+			return ErrClientIDInUse
+		}
+	}
+
+	created, err := s.SetNX(ctx, accountKeyPrefix+account.Name, data, 0)
+	if err == nil && !created {
+		err = ErrExists
+	}
+	if err != nil && account.ClientID != "" {
+		_ = s.Delete(ctx, clientKeyPrefix+account.ClientID)
+	}
+	return err
+}
+
+func Get(ctx context.Context, s store.Store, name string) (*Account, error) {
+	data, err := s.Get(ctx, accountKeyPrefix+name)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var account Account
+	if err := json.Unmarshal(data, &account); err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// GetByClientID resolves the account mapped to a Casdoor application client id
+func GetByClientID(ctx context.Context, s store.Store, clientID string) (*Account, error) {
+	name, err := s.Get(ctx, clientKeyPrefix+clientID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return Get(ctx, s, string(name))
+}
+
+func List(ctx context.Context, s store.Store) ([]*Account, error) {
+	items, err := s.List(ctx, accountKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := []*Account{}
+	for _, data := range items {
+		var account Account
+		if err := json.Unmarshal(data, &account); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, &account)
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Name < accounts[j].Name })
+	return accounts, nil
+}
+
+// Delete removes the account together with all its keys
+func Delete(ctx context.Context, s store.Store, name string) error {
+	account, err := Get(ctx, s, name)
+	if err != nil {
+		return err
+	}
+
+	keys, err := ListKeys(ctx, s, name)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := s.Delete(ctx, apiKeyKeyPrefix+key.ID); err != nil {
+			return err
+		}
+	}
+
+	if account.ClientID != "" {
+		// Only our own mapping, never one another account holds
+		owner, err := s.Get(ctx, clientKeyPrefix+account.ClientID)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+		if err == nil && string(owner) == name {
+			if err := s.Delete(ctx, clientKeyPrefix+account.ClientID); err != nil {
+				return err
+			}
+		}
+	}
+	return s.Delete(ctx, accountKeyPrefix+name)
+}
+
+// CreateKey issues a new API key for the account. The plaintext key is only
+// returned here and cannot be recovered later.
+func CreateKey(ctx context.Context, s store.Store, account, name string) (*Key, string, error) {
+	if _, err := Get(ctx, s, account); err != nil {
+		return nil, "", err
+	}
+
+	id := secrets.HexID(8)
+	secret := secrets.Token()
+	key := &Key{
+		ID:        id,
+		Account:   account,
+		Name:      name,
+		Hash:      secrets.Hash(secret),
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := saveKey(ctx, s, key); err != nil {
+		return nil, "", err
+	}
+	return key, formatKey(id, secret), nil
+}
+
+func GetKey(ctx context.Context, s store.Store, account, id string) (*Key, error) {
+	data, err := s.Get(ctx, apiKeyKeyPrefix+id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var key Key
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, err
+	}
+	if account != "" && key.Account != account {
+		return nil, ErrNotFound
+	}
+	return &key, nil
+}
+
+func ListKeys(ctx context.Context, s store.Store, account string) ([]*Key, error) {
+	items, err := s.List(ctx, apiKeyKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []*Key{}
+	for _, data := range items {
+		var key Key
+		if err := json.Unmarshal(data, &key); err != nil {
+			return nil, err
+		}
+		if key.Account == account {
+			keys = append(keys, &key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+// RotateKey replaces the secret of a key, the old secret stops working immediately
+func RotateKey(ctx context.Context, s store.Store, account, id string) (*Key, string, error) {
+	key, err := GetKey(ctx, s, account, id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	secret := secrets.Token()
+	now := time.Now().UTC()
+	key.Hash = secrets.Hash(secret)
+	key.RotatedAt = &now
+	if err := saveKey(ctx, s, key); err != nil {
+		return nil, "", err
+	}
+	return key, formatKey(id, secret), nil
+}
+
+// RevokeKey deletes a key
+func RevokeKey(ctx context.Context, s store.Store, account, id string) error {
+	if _, err := GetKey(ctx, s, account, id); err != nil {
+		return err
+	}
+	return s.Delete(ctx, apiKeyKeyPrefix+id)
+}
+
+// Authenticate checks a plaintext API key and returns its account, updating last-used
+func Authenticate(ctx context.Context, s store.Store, raw string) (*Account, *Key, error) {
+	id, secret, ok := parseKey(raw)
+	if !ok {
+		return nil, nil, ErrInvalidKey
+	}
+
+	key, err := GetKey(ctx, s, "", id)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(secrets.Hash(secret))) != 1 {
+		return nil, nil, ErrInvalidKey
+	}
+
+	account, err := Get(ctx, s, key.Account)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now().UTC()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > lastUsedResolution {
+		key.LastUsedAt = &now
+		_ = saveKey(ctx, s, key)
+	}
+	return account, key, nil
+}
+
+func saveKey(ctx context.Context, s store.Store, key *Key) error {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	return s.Set(ctx, apiKeyKeyPrefix+key.ID, data, 0)
+}
+
+// formatKey builds "sk_<id>_<secret>", the id lets us find the hash without scanning
+func formatKey(id, secret string) string {
+	return KeyPrefix + id + "_" + secret
+}
+
+func parseKey(raw string) (id, secret string, ok bool) {
+	if !strings.HasPrefix(raw, KeyPrefix) {
+		return "", "", false
+	}
+	id, secret, ok = strings.Cut(strings.TrimPrefix(raw, KeyPrefix), "_")
+	return id, secret, ok && id != "" && secret != ""
+}
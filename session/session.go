@@ -0,0 +1,85 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/skyapps-id/casdoor-test/securecookie"
+	"github.com/skyapps-id/casdoor-test/store"
+)
+
+const (
+	CookieName     = "session"
+	CSRFCookieName = "csrf_token"
+	CSRFHeaderName = "X-CSRF-Token"
+
+	keyPrefix     = "session:"
+	refreshPrefix = "session-refresh:"
+)
+
+// Session maps an opaque browser cookie to the tokens kept on the server (BFF mode)
+type Session struct {
+	ID           string    `json:"id"`
+	User         string    `json:"user"`
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	IDToken      string    `json:"id_token"`
+	Expiry       time.Time `json:"expiry"`
+	CSRFToken    string    `json:"csrf_token"`
+}
+
+// Save stores the session for ttl
+func Save(ctx context.Context, s store.Store, sess *Session, ttl time.Duration) error {
+	data, err := json.Marshal(sess)
+	if err != nil {
+		return err
+	}
+	return s.Set(ctx, keyPrefix+sess.ID, data, ttl)
+}
+
+// Load returns store.ErrNotFound for unknown or expired sessions
+func Load(ctx context.Context, s store.Store, id string) (*Session, error) {
+	data, err := s.Get(ctx, keyPrefix+id)
+	if err != nil {
+		return nil, err
+	}
+
+	var sess Session
+	if err := json.Unmarshal(data, &sess); err != nil {
+		return nil, err
+	}
+	return &sess, nil
+}
+
+func Delete(ctx context.Context, s store.Store, id string) error {
+	return s.Delete(ctx, keyPrefix+id)
+}
+
+// LockRefresh lets one request refresh the session's tokens at a time, also
+// across instances. The lock expires after ttl in case its holder dies.
+func LockRefresh(ctx context.Context, s store.Store, id string, ttl time.Duration) (bool, error) {
+	return s.SetNX(ctx, refreshPrefix+id, []byte("1"), ttl)
+}
+
+// UnlockRefresh releases the lock taken by LockRefresh
+func UnlockRefresh(ctx context.Context, s store.Store, id string) error {
+	return s.Delete(ctx, refreshPrefix+id)
+}
+
+// AccessTokenExpired reports whether the access token expires within leeway
+func (s *Session) AccessTokenExpired(leeway time.Duration) bool {
+	return !s.Expiry.IsZero() && time.Now().Add(leeway).After(s.Expiry)
+}
+
+// EncodeCookie encrypts the session id for the session cookie
+func EncodeCookie(secret []byte, id string, ttl time.Duration) (string, error) {
+	return securecookie.Encrypt(secret, CookieName, id, ttl)
+}
+
+// DecodeCookie returns the session id carried by the session cookie
+func DecodeCookie(secret []byte, value string) (string, error) {
+	var id string
+	err := securecookie.Decrypt(secret, CookieName, value, &id)
+	return id, err
+}
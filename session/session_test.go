@@ -0,0 +1,83 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/skyapps-id/casdoor-test/store"
+)
+
+func TestCookie(t *testing.T) {
+	secret := []byte("secret")
+	value, err := EncodeCookie(secret, "s-1", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := DecodeCookie(secret, value); err != nil || id != "s-1" {
+		t.Errorf("DecodeCookie = %q, %v, want s-1", id, err)
+	}
+	if _, err := DecodeCookie([]byte("other"), value); err == nil {
+		t.Error("DecodeCookie accepted a cookie made with another secret")
+	}
+}
+
+func TestSaveLoadDelete(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemoryStore()
+
+	sess := &Session{ID: "s-1", User: "alice", AccessToken: "at", CSRFToken: "csrf"}
+	if err := Save(ctx, s, sess, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Load(ctx, s, "s-1")
+	if err != nil || *got != *sess {
+		t.Fatalf("Load = %+v, %v, want %+v", got, err, sess)
+	}
+	if err := Delete(ctx, s, "s-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(ctx, s, "s-1"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Load after Delete error = %v, want store.ErrNotFound", err)
+	}
+}
+
+func TestLockRefresh(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemoryStore()
+
+	if ok, err := LockRefresh(ctx, s, "s-1", time.Minute); !ok || err != nil {
+		t.Fatalf("first LockRefresh = %v, %v, want true", ok, err)
+	}
+	if ok, _ := LockRefresh(ctx, s, "s-1", time.Minute); ok {
+		t.Error("second LockRefresh succeeded while the lock is held")
+	}
+	if ok, _ := LockRefresh(ctx, s, "s-2", time.Minute); !ok {
+		t.Error("LockRefresh of another session failed")
+	}
+	if err := UnlockRefresh(ctx, s, "s-1"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := LockRefresh(ctx, s, "s-1", time.Minute); !ok {
+		t.Error("LockRefresh failed after UnlockRefresh")
+	}
+}
+
+func TestAccessTokenExpired(t *testing.T) {
+	tests := []struct {
+		expiry time.Time
+		want   bool
+	}{
+		{time.Time{}, false},
+		{time.Now().Add(time.Hour), false},
+		{time.Now().Add(10 * time.Second), true},
+		{time.Now().Add(-time.Minute), true},
+	}
+	for _, tt := range tests {
+		sess := &Session{Expiry: tt.expiry}
+		if got := sess.AccessTokenExpired(30 * time.Second); got != tt.want {
+			t.Errorf("AccessTokenExpired(expiry %s) = %v, want %v", tt.expiry, got, tt.want)
+		}
+	}
+}
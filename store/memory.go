@@ -0,0 +1,116 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryItem struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+func (i memoryItem) expired(now time.Time) bool {
+	return !i.expiresAt.IsZero() && now.After(i.expiresAt)
+}
+
+// MemoryStore keeps everything in process, only suitable for a single instance
+type MemoryStore struct {
+	mu    sync.Mutex
+	items map[string]memoryItem
+}
+
+// NewMemoryStore creates a store and starts a janitor removing expired keys
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{items: map[string]memoryItem{}}
+	go s.janitor(time.Minute)
+	return s
+}
+
+func (s *MemoryStore) Get(_ context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, ok := s.items[key]
+	if !ok || item.expired(time.Now()) {
+		return nil, ErrNotFound
+	}
+	return item.value, nil
+}
+
+func (s *MemoryStore) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.items[key] = newMemoryItem(value, ttl)
+	return nil
+}
+
+func (s *MemoryStore) SetNX(_ context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if item, ok := s.items[key]; ok && !item.expired(time.Now()) {
+		return false, nil
+	}
+	s.items[key] = newMemoryItem(value, ttl)
+	return true, nil
+}
+
+func (s *MemoryStore) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.items, key)
+	return nil
+}
+
+func (s *MemoryStore) DeleteIf(_ context.Context, key string, value []byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, ok := s.items[key]
+	if !ok || item.expired(time.Now()) || !bytes.Equal(item.value, value) {
+		return false, nil
+	}
+	delete(s.items, key)
+	return true, nil
+}
+
+func (s *MemoryStore) List(_ context.Context, prefix string) (map[string][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	items := map[string][]byte{}
+	for key, item := range s.items {
+		if strings.HasPrefix(key, prefix) && !item.expired(now) {
+			items[key] = item.value
+		}
+	}
+	return items, nil
+}
+
+func (s *MemoryStore) janitor(interval time.Duration) {
+	for range time.Tick(interval) {
+		now := time.Now()
+		s.mu.Lock()
+		for key, item := range s.items {
+			if item.expired(now) {
+				delete(s.items, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+func newMemoryItem(value []byte, ttl time.Duration) memoryItem {
+	item := memoryItem{value: value}
+	if ttl > 0 {
+		item.expiresAt = time.Now().Add(ttl)
+	}
+	return item
+}
@@ -0,0 +1,32 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreDeleteIf(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+
+	_ = s.Set(ctx, "k", []byte("v1"), 0)
+	if ok, _ := s.DeleteIf(ctx, "k", []byte("v0")); ok {
+		t.Error("DeleteIf deleted a key holding another value")
+	}
+	if _, err := s.Get(ctx, "k"); err != nil {
+		t.Errorf("Get after a refused DeleteIf: %v", err)
+	}
+	if ok, _ := s.DeleteIf(ctx, "k", []byte("v1")); !ok {
+		t.Error("DeleteIf kept a key holding the value")
+	}
+	if ok, _ := s.DeleteIf(ctx, "k", []byte("v1")); ok {
+		t.Error("DeleteIf reported deleting a missing key")
+	}
+
+	_ = s.Set(ctx, "old", []byte("v"), time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if ok, _ := s.DeleteIf(ctx, "old", []byte("v")); ok {
+		t.Error("DeleteIf reported deleting an expired key")
+	}
+}
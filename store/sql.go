@@ -0,0 +1,129 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// SQLStore keeps keys in the kv_store table (SQLite or Postgres) so several
+// instances can share state. Expiry is stored as unix milliseconds, 0 = never.
+type SQLStore struct {
+	db *sql.DB
+}
+
+// NewSQLStore creates the kv_store table if needed
+func NewSQLStore(db *sql.DB) (*SQLStore, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS kv_store (
+		name       TEXT PRIMARY KEY,
+		value      TEXT NOT NULL,
+		expires_at BIGINT NOT NULL DEFAULT 0
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLStore{db: db}, nil
+}
+
+func (s *SQLStore) Get(ctx context.Context, key string) ([]byte, error) {
+	var value string
+	err := s.db.QueryRowContext(ctx,
+		`SELECT value FROM kv_store WHERE name = $1 AND (expires_at = 0 OR expires_at > $2)`,
+		key, nowMillis(),
+	).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []byte(value), nil
+}
+
+func (s *SQLStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO kv_store (name, value, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at`,
+		key, string(value), expiresAt(ttl),
+	)
+	return err
+}
+
+func (s *SQLStore) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	// An expired row must not block the insert
+	if _, err := s.db.ExecContext(ctx,
+		`DELETE FROM kv_store WHERE name = $1 AND expires_at <> 0 AND expires_at <= $2`,
+		key, nowMillis(),
+	); err != nil {
+		return false, err
+	}
+
+	res, err := s.db.ExecContext(ctx,
+		`INSERT INTO kv_store (name, value, expires_at) VALUES ($1, $2, $3) ON CONFLICT (name) DO NOTHING`,
+		key, string(value), expiresAt(ttl),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	return affected == 1, err
+}
+
+func (s *SQLStore) Delete(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM kv_store WHERE name = $1`, key)
+	return err
+}
+
+func (s *SQLStore) DeleteIf(ctx context.Context, key string, value []byte) (bool, error) {
+	res, err := s.db.ExecContext(ctx,
+		`DELETE FROM kv_store WHERE name = $1 AND value = $2 AND (expires_at = 0 OR expires_at > $3)`,
+		key, string(value), nowMillis(),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	return affected == 1, err
+}
+
+func (s *SQLStore) List(ctx context.Context, prefix string) (map[string][]byte, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT name, value FROM kv_store WHERE name LIKE $1 AND (expires_at = 0 OR expires_at > $2)`,
+		prefix+"%", nowMillis(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := map[string][]byte{}
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		items[name] = []byte(value)
+	}
+	return items, rows.Err()
+}
+
+// Cleanup removes expired rows, call it periodically
+func (s *SQLStore) Cleanup(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx,
+		`DELETE FROM kv_store WHERE expires_at <> 0 AND expires_at <= $1`, nowMillis())
+	return err
+}
+
+func nowMillis() int64 {
+	return time.Now().UnixMilli()
+}
+
+func expiresAt(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return time.Now().Add(ttl).UnixMilli()
+}
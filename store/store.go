@@ -0,0 +1,25 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrNotFound = errors.New("store: key not found")
+
+// Store is a small key/value abstraction for server-side state (token
+// families, revocations, sessions, ...). A ttl of 0 means no expiry.
+type Store interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// SetNX only stores the value when the key is absent and reports whether it did
+	SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
+	Delete(ctx context.Context, key string) error
+	// DeleteIf only deletes the key while it still holds value and reports
+	// whether it did
+	DeleteIf(ctx context.Context, key string, value []byte) (bool, error)
+	// List returns every live key starting with prefix. Keep "%" and "_" out
+	// of prefixes, the SQL store matches them with LIKE.
+	List(ctx context.Context, prefix string) (map[string][]byte, error)
+}
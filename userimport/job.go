@@ -0,0 +1,154 @@
+package userimport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/skyapps-id/casdoor-test/internal/secrets"
+	"github.com/skyapps-id/casdoor-test/store"
+	"github.com/skyapps-id/casdoor-test/validation"
+)
+
+const jobKeyPrefix = "import:"
+
+var ErrNotFound = errors.New("userimport: job not found")
+
+// Job and row states
+const (
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+
+	RowValid   = "valid"
+	RowInvalid = "invalid"
+	RowCreated = "created"
+	RowFailed  = "failed"
+)
+
+// Result reports what happened to one row
+type Result struct {
+	Line     int                     `json:"line"`
+	Username string                  `json:"username"`
+	Status   string                  `json:"status"`
+	Error    string                  `json:"error,omitempty"`
+	Fields   []validation.FieldError `json:"fields,omitempty"`
+}
+
+// Job is an import run. Asynchronous jobs are kept in the store so their
+// progress can be polled.
+type Job struct {
+	ID         string     `json:"id"`
+	Actor      string     `json:"actor"`
+	Status     string     `json:"status"`
+	Total      int        `json:"total"`
+	Processed  int        `json:"processed"`
+	Created    int        `json:"created"`
+	Failed     int        `json:"failed"`
+	Results    []Result   `json:"results"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// NewJob starts a job for rows
+func NewJob(actor string, rows []Row) *Job {
+	results := make([]Result, len(rows))
+	for i, row := range rows {
+		results[i] = Result{Line: row.Line, Username: row.Username}
+	}
+	return &Job{
+		ID:        secrets.HexID(8),
+		Actor:     actor,
+		Status:    StatusRunning,
+		Total:     len(rows),
+		Results:   results,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// Process calls create for every row with at most concurrency calls in flight.
+// checkpoint runs after each row, serialized, and may read the job.
+func (j *Job) Process(ctx context.Context, rows []Row, concurrency int, create func(context.Context, Row) error, checkpoint func(*Job)) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	for i, row := range rows {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, row Row) {
+			defer func() {
+				// A panicking checkpoint must not take the server down either
+				if r := recover(); r != nil {
+					log.Printf("import %s: panic after line %d: %v\n%s", j.ID, row.Line, r, debug.Stack())
+				}
+				<-sem
+				wg.Done()
+			}()
+			err := safeCreate(ctx, create, row)
+
+			mu.Lock()
+			defer mu.Unlock()
+			j.Processed++
+			if err != nil {
+				j.Failed++
+				j.Results[i].Status = RowFailed
+				j.Results[i].Error = err.Error()
+			} else {
+				j.Created++
+				j.Results[i].Status = RowCreated
+			}
+			if checkpoint != nil {
+				checkpoint(j)
+			}
+		}(i, row)
+	}
+	wg.Wait()
+
+	now := time.Now().UTC()
+	j.Status = StatusCompleted
+	j.FinishedAt = &now
+}
+
+// safeCreate turns a panic while importing a row into a failed row
+func safeCreate(ctx context.Context, create func(context.Context, Row) error, row Row) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("import: panic on line %d: %v\n%s", row.Line, r, debug.Stack())
+			err = errors.New("internal error")
+		}
+	}()
+	return create(ctx, row)
+}
+
+// Save stores the job for ttl
+func Save(ctx context.Context, s store.Store, job *Job, ttl time.Duration) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return s.Set(ctx, jobKeyPrefix+job.ID, data, ttl)
+}
+
+// Get loads a stored job
+func Get(ctx context.Context, s store.Store, id string) (*Job, error) {
+	data, err := s.Get(ctx, jobKeyPrefix+id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
@@ -0,0 +1,39 @@
+package userimport
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestJobProcess(t *testing.T) {
+	rows := []Row{{Line: 2, Username: "alice"}, {Line: 3, Username: "bob"}, {Line: 4, Username: "carol"}}
+	job := NewJob("admin", rows)
+
+	job.Process(context.Background(), rows, 2, func(_ context.Context, row Row) error {
+		switch row.Username {
+		case "bob":
+			return errors.New("already exists")
+		case "carol":
+			panic("nil user")
+		}
+		return nil
+	}, nil)
+
+	if job.Status != StatusCompleted || job.FinishedAt == nil {
+		t.Fatalf("job status = %s, want completed", job.Status)
+	}
+	if job.Processed != 3 || job.Created != 1 || job.Failed != 2 {
+		t.Errorf("processed/created/failed = %d/%d/%d, want 3/1/2", job.Processed, job.Created, job.Failed)
+	}
+	want := []struct{ status, err string }{
+		{RowCreated, ""},
+		{RowFailed, "already exists"},
+		{RowFailed, "internal error"},
+	}
+	for i, w := range want {
+		if got := job.Results[i]; got.Status != w.status || got.Error != w.err {
+			t.Errorf("row %d = %s %q, want %s %q", got.Line, got.Status, got.Error, w.status, w.err)
+		}
+	}
+}
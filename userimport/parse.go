@@ -0,0 +1,149 @@
+package userimport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Formats accepted by Parse
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+var ErrTooManyRows = errors.New("userimport: too many rows")
+
+// Row is one user of an import file. Either Password or Invite is set:
+// invited users get a random password they replace through Casdoor.
+type Row struct {
+	Line        int      `json:"-"`
+	Username    string   `json:"username" validate:"required,username"`
+	DisplayName string   `json:"display_name" validate:"required,max=100"`
+	Email       string   `json:"email" validate:"required,email"`
+	Password    string   `json:"password" validate:"required_without=Invite,excluded_with=Invite,omitempty,password"`
+	Invite      bool     `json:"invite"`
+	Roles       []string `json:"roles" validate:"max=20,dive,identifier"`
+}
+
+var csvColumns = map[string]bool{
+	"username": true, "display_name": true, "email": true,
+	"password": true, "invite": true, "roles": true,
+}
+
+// Parse reads the rows of a CSV file with a header line (username,
+// display_name, email, password, invite, roles separated by ";") or of an
+// NDJSON file with one object per line. More than maxRows rows fail with
+// ErrTooManyRows.
+func Parse(r io.Reader, format string, maxRows int) ([]Row, error) {
+	switch format {
+	case FormatCSV:
+		return parseCSV(r, maxRows)
+	case FormatNDJSON:
+		return parseNDJSON(r, maxRows)
+	}
+	return nil, fmt.Errorf("unsupported format %q (use csv or ndjson)", format)
+}
+
+func parseCSV(r io.Reader, maxRows int) ([]Row, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("empty file")
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !csvColumns[name] {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		columns[name] = i
+	}
+	if _, ok := columns["username"]; !ok {
+		return nil, errors.New("missing username column")
+	}
+
+	rows := []Row{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		if len(rows) == maxRows {
+			return nil, ErrTooManyRows
+		}
+
+		get := func(column string) string {
+			if i, ok := columns[column]; ok {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		row := Row{
+			Line:        line,
+			Username:    get("username"),
+			DisplayName: get("display_name"),
+			Email:       get("email"),
+			Password:    get("password"),
+		}
+		if invite := get("invite"); invite != "" {
+			row.Invite, err = strconv.ParseBool(invite)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid invite value %q", line, invite)
+			}
+		}
+		for _, role := range strings.Split(get("roles"), ";") {
+			if role = strings.TrimSpace(role); role != "" {
+				row.Roles = append(row.Roles, role)
+			}
+		}
+		rows = append(rows, row)
+	}
+}
+
+func parseNDJSON(r io.Reader, maxRows int) ([]Row, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	rows := []Row{}
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		if len(rows) == maxRows {
+			return nil, ErrTooManyRows
+		}
+
+		var row Row
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&row); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		row.Line = line
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("empty file")
+	}
+	return rows, nil
+}
@@ -0,0 +1,130 @@
+package userimport
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		input   string
+		maxRows int
+		want    []Row
+		wantErr string
+	}{
+		{
+			name:   "csv",
+			format: FormatCSV,
+			input: "username,display_name,email,password,roles\n" +
+				"alice,Alice,alice@example.com,S3cret!pw,admin; user\n" +
+				"bob, Bob ,bob@example.com,,\n",
+			maxRows: 10,
+			want: []Row{
+				{Line: 2, Username: "alice", DisplayName: "Alice", Email: "alice@example.com", Password: "S3cret!pw", Roles: []string{"admin", "user"}},
+				{Line: 3, Username: "bob", DisplayName: "Bob", Email: "bob@example.com"},
+			},
+		},
+		{
+			name:    "csv columns in any order",
+			format:  FormatCSV,
+			input:   "Invite,Username\ntrue,carol\n",
+			maxRows: 10,
+			want:    []Row{{Line: 2, Username: "carol", Invite: true}},
+		},
+		{
+			name:    "csv unknown column",
+			format:  FormatCSV,
+			input:   "username,is_admin\nalice,true\n",
+			maxRows: 10,
+			wantErr: `unknown column "is_admin"`,
+		},
+		{
+			name:    "csv without username",
+			format:  FormatCSV,
+			input:   "email\nalice@example.com\n",
+			maxRows: 10,
+			wantErr: "missing username column",
+		},
+		{
+			name:    "csv invalid invite",
+			format:  FormatCSV,
+			input:   "username,invite\nalice,maybe\n",
+			maxRows: 10,
+			wantErr: `line 2: invalid invite value "maybe"`,
+		},
+		{
+			name:    "csv empty",
+			format:  FormatCSV,
+			input:   "",
+			maxRows: 10,
+			wantErr: "empty file",
+		},
+		{
+			name:   "ndjson",
+			format: FormatNDJSON,
+			input: `{"username":"alice","display_name":"Alice","email":"alice@example.com","invite":true,"roles":["user"]}` + "\n\n" +
+				`{"username":"bob","password":"S3cret!pw"}` + "\n",
+			maxRows: 10,
+			want: []Row{
+				{Line: 1, Username: "alice", DisplayName: "Alice", Email: "alice@example.com", Invite: true, Roles: []string{"user"}},
+				{Line: 3, Username: "bob", Password: "S3cret!pw"},
+			},
+		},
+		{
+			name:    "ndjson unknown field",
+			format:  FormatNDJSON,
+			input:   `{"username":"alice","admin":true}`,
+			maxRows: 10,
+			wantErr: `line 1: json: unknown field "admin"`,
+		},
+		{
+			name:    "ndjson empty",
+			format:  FormatNDJSON,
+			input:   "\n\n",
+			maxRows: 10,
+			wantErr: "empty file",
+		},
+		{
+			name:    "unknown format",
+			format:  "xml",
+			maxRows: 10,
+			wantErr: `unsupported format "xml" (use csv or ndjson)`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := Parse(strings.NewReader(tt.input), tt.format, tt.maxRows)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("Parse error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if !reflect.DeepEqual(rows, tt.want) {
+				t.Errorf("Parse = %+v, want %+v", rows, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTooManyRows(t *testing.T) {
+	inputs := map[string]string{
+		FormatCSV:    "username\na\nb\nc\n",
+		FormatNDJSON: `{"username":"a"}` + "\n" + `{"username":"b"}` + "\n" + `{"username":"c"}` + "\n",
+	}
+	for format, input := range inputs {
+		if _, err := Parse(strings.NewReader(input), format, 2); !errors.Is(err, ErrTooManyRows) {
+			t.Errorf("Parse(%s) with 3 rows error = %v, want ErrTooManyRows", format, err)
+		}
+		if rows, err := Parse(strings.NewReader(input), format, 3); err != nil || len(rows) != 3 {
+			t.Errorf("Parse(%s) with 3 allowed rows = %d rows, %v", format, len(rows), err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package validation
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// PasswordPolicy is the password rule applied by the "password" tag
+type PasswordPolicy struct {
+	MinLength  int
+	MinClasses int                 // of lowercase, uppercase, digits, symbols
+	Breached   map[string]struct{} // lower-cased known breached passwords
+}
+
+// Check returns why password violates the policy, nil when it is acceptable
+func (p PasswordPolicy) Check(password string) error {
+	if len([]rune(password)) < p.MinLength {
+		return fmt.Errorf("must be at least %d characters", p.MinLength)
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	classes := 0
+	for _, has := range []bool{lower, upper, digit, symbol} {
+		if has {
+			classes++
+		}
+	}
+	if classes < p.MinClasses {
+		return fmt.Errorf("must contain at least %d of: lowercase letters, uppercase letters, digits, symbols", p.MinClasses)
+	}
+
+	if _, ok := p.Breached[strings.ToLower(password)]; ok {
+		return fmt.Errorf("appears in a list of breached passwords")
+	}
+	return nil
+}
+
+// LoadBreachedList reads one password per line, blank lines and # comments are skipped
+func LoadBreachedList(path string) (map[string]struct{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	list := map[string]struct{}{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		list[strings.ToLower(line)] = struct{}{}
+	}
+	return list, scanner.Err()
+}
@@ -0,0 +1,34 @@
+package validation
+
+import "testing"
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	policy := PasswordPolicy{
+		MinLength:  8,
+		MinClasses: 3,
+		Breached:   map[string]struct{}{"password1!": {}},
+	}
+
+	tests := []struct {
+		password string
+		wantErr  string
+	}{
+		{"Tr0ub4dor", ""},
+		{"correct horse", "must contain at least 3 of: lowercase letters, uppercase letters, digits, symbols"},
+		{"Sh0rt!", "must be at least 8 characters"},
+		{"ÄäÖö12345", ""},
+		{"ÄäÖö", "must be at least 8 characters"},
+		{"alllowercase", "must contain at least 3 of: lowercase letters, uppercase letters, digits, symbols"},
+		{"PASSWORD1!", "appears in a list of breached passwords"},
+	}
+	for _, tt := range tests {
+		err := policy.Check(tt.password)
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != tt.wantErr {
+			t.Errorf("Check(%q) = %q, want %q", tt.password, got, tt.wantErr)
+		}
+	}
+}
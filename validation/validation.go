@@ -0,0 +1,156 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// FieldError describes one invalid request field, named as in the JSON body
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Errors is returned by Validate when fields are invalid
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, f := range e {
+		msgs = append(msgs, f.Field+" "+f.Message)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Validator enforces the `validate` struct tags for Echo (c.Validate).
+// Besides the built-in rules it knows "username", "identifier" and "password".
+type Validator struct {
+	validate *validator.Validate
+	username *regexp.Regexp
+	password PasswordPolicy
+}
+
+var identifierPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]{0,99}$`)
+
+// New creates the validator with the username pattern and password policy
+func New(usernamePattern string, password PasswordPolicy) (*Validator, error) {
+	username, err := regexp.Compile(usernamePattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid username pattern: %w", err)
+	}
+
+	v := &Validator{
+		validate: validator.New(),
+		username: username,
+		password: password,
+	}
+
+	// Report fields by their JSON name
+	v.validate.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name := strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+		if name == "-" || name == "" {
+			return f.Name
+		}
+		return name
+	})
+	_ = v.validate.RegisterValidation("username", func(fl validator.FieldLevel) bool {
+		return v.username.MatchString(fl.Field().String())
+	})
+	_ = v.validate.RegisterValidation("identifier", func(fl validator.FieldLevel) bool {
+		return identifierPattern.MatchString(fl.Field().String())
+	})
+	_ = v.validate.RegisterValidation("password", func(fl validator.FieldLevel) bool {
+		return v.password.Check(fl.Field().String()) == nil
+	})
+	return v, nil
+}
+
+// Validate implements echo.Validator
+func (v *Validator) Validate(i interface{}) error {
+	err := v.validate.Struct(i)
+	if err == nil {
+		return nil
+	}
+
+	var invalid validator.ValidationErrors
+	if !errors.As(err, &invalid) {
+		return err
+	}
+
+	fields := make(Errors, 0, len(invalid))
+	for _, fe := range invalid {
+		fields = append(fields, FieldError{
+			Field:   fieldPath(fe),
+			Rule:    fe.Tag(),
+			Message: v.message(fe),
+		})
+	}
+	return fields
+}
+
+// fieldPath drops the struct name from the namespace ("req.roles[0]" → "roles[0]")
+func fieldPath(fe validator.FieldError) string {
+	ns := fe.Namespace()
+	if i := strings.Index(ns, "."); i >= 0 {
+		return ns[i+1:]
+	}
+	return fe.Field()
+}
+
+func (v *Validator) message(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "min":
+		switch {
+		case fe.Kind() == reflect.String:
+			return "must be at least " + fe.Param() + " characters"
+		case isNumber(fe.Kind()):
+			return "must be at least " + fe.Param()
+		}
+		return "must contain at least " + fe.Param() + " items"
+	case "max":
+		switch {
+		case fe.Kind() == reflect.String:
+			return "must be at most " + fe.Param() + " characters"
+		case isNumber(fe.Kind()):
+			return "must be at most " + fe.Param()
+		}
+		return "must contain at most " + fe.Param() + " items"
+	case "required_without":
+		return "is required unless " + strings.ToLower(fe.Param()) + " is set"
+	case "excluded_with":
+		return "must be empty when " + strings.ToLower(fe.Param()) + " is set"
+	case "unique":
+		return "must not contain duplicates"
+	case "oneof":
+		return "must be one of: " + fe.Param()
+	case "username":
+		return "must match " + v.username.String()
+	case "identifier":
+		return "may only contain letters, digits, - and _ and must start with a letter or digit"
+	case "password":
+		if err := v.password.Check(fmt.Sprint(fe.Value())); err != nil {
+			return err.Error()
+		}
+	}
+	return "failed the " + fe.Tag() + " rule"
+}
+
+func isNumber(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package validation
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestValidateMessages(t *testing.T) {
+	v, err := New(`^[a-z][a-z0-9_]{2,19}$`, PasswordPolicy{MinLength: 8, MinClasses: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type request struct {
+		Username  string   `json:"username" validate:"required,username"`
+		Password  string   `json:"password" validate:"omitempty,password"`
+		Roles     []string `json:"roles" validate:"max=2,dive,identifier"`
+		ExpiresIn int      `json:"expires_in" validate:"omitempty,min=60,max=3600"`
+	}
+
+	tests := []struct {
+		name string
+		req  request
+		want Errors
+	}{
+		{"valid", request{Username: "alice", Roles: []string{"admin"}, ExpiresIn: 60}, nil},
+		{"missing username", request{}, Errors{
+			{Field: "username", Rule: "required", Message: "is required"},
+		}},
+		{"pattern", request{Username: "Alice"}, Errors{
+			{Field: "username", Rule: "username", Message: "must match ^[a-z][a-z0-9_]{2,19}$"},
+		}},
+		{"password policy", request{Username: "alice", Password: "short"}, Errors{
+			{Field: "password", Rule: "password", Message: "must be at least 8 characters"},
+		}},
+		{"list size", request{Username: "alice", Roles: []string{"a", "b", "c"}}, Errors{
+			{Field: "roles", Rule: "max", Message: "must contain at most 2 items"},
+		}},
+		{"list element", request{Username: "alice", Roles: []string{"-admin"}}, Errors{
+			{Field: "roles[0]", Rule: "identifier", Message: "may only contain letters, digits, - and _ and must start with a letter or digit"},
+		}},
+		{"number too small", request{Username: "alice", ExpiresIn: 5}, Errors{
+			{Field: "expires_in", Rule: "min", Message: "must be at least 60"},
+		}},
+		{"number too large", request{Username: "alice", ExpiresIn: 7200}, Errors{
+			{Field: "expires_in", Rule: "max", Message: "must be at most 3600"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Errors
+			if err := v.Validate(&tt.req); err != nil && !errors.As(err, &got) {
+				t.Fatalf("Validate returned %v, want Errors", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}